@@ -0,0 +1,180 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/bradleyjkemp/osquery-ja3/ja3assembler"
+	"github.com/bradleyjkemp/osquery-ja3/procinfo"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+// snapshotLength is how much of each packet is captured. Whole packets are needed: the rest of a truncated
+// TCP segment is missing from the stream, so the server's Certificate message (which spans several full-size
+// segments) would be lost. QUIC Initial packets can only be decrypted if the whole datagram (at least 1200 bytes)
+// is captured, and DTLS records can't be split across datagrams.
+const snapshotLength = 65535
+
+const (
+	// flushInterval is how often live captures check for connections which have gone quiet part way through a handshake
+	flushInterval = 10 * time.Second
+	// idleTimeout is how long a connection can go without a packet before its handshake is given up on
+	idleTimeout = time.Minute
+	// attributionQueueLength is how many handshakes can be waiting for their process to be looked up
+	attributionQueueLength = 1024
+)
+
+// bootTime is used to work out the system uptime at the time of each live handshake
+var bootTime, bootTimeErr = procinfo.BootTime()
+
+func logJA3Hashes(iface string, callback func(handshakeEvent)) {
+	pcapHandle, err := pcap.OpenLive(iface, snapshotLength, true, pcap.BlockForever)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer pcapHandle.Close()
+	if *verbose {
+		log.Println("Logging JA3(S) hashes on", iface)
+		defer func() {
+			log.Println("Stopped logging hashes on", iface)
+		}()
+	}
+
+	// Looking up the process means reading /proc so is done on another goroutine, rather than holding up
+	// packets while it happens
+	pending := make(chan handshakeEvent, attributionQueueLength)
+	defer close(pending)
+	go attributeEvents(pending, callback)
+
+	assembler := ja3assembler.NewAssembler(func(handshake ja3assembler.Handshake) {
+		event := handshakeEvent{
+			time:      handshake.Time,
+			uptime:    uptimeAt(handshake.Time),
+			iface:     iface,
+			handshake: handshake,
+		}
+		select {
+		case pending <- event:
+		default:
+			// The lookups can't keep up so record the handshake without them rather than dropping packets
+			callback(event)
+		}
+	})
+	assembler.Verbose = *verbose
+	// Otherwise a connection which stalls part way through its handshake would never be reported
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+	assemblePackets(pcapHandle, assembler, flush.C)
+}
+
+// attributeEvents adds the process and container to each event before passing it to the callback,
+// until the channel is closed.
+func attributeEvents(pending <-chan handshakeEvent, callback func(handshakeEvent)) {
+	for event := range pending {
+		// This has to happen now rather than when the table is queried as short-lived processes will have gone by then
+		event.process = attributeProcess(event.handshake)
+		event.container = attributeContainer(event.iface, event.process)
+		callback(event)
+	}
+}
+
+// readPcapFiles reads each capture file in turn through a single assembler so that
+// connections split across consecutive files are still reassembled.
+func readPcapFiles(paths []string, callback func(handshakeEvent)) {
+	assembler := ja3assembler.NewAssembler(func(handshake ja3assembler.Handshake) {
+		// The processes which made these connections aren't necessarily on this machine so can't be attributed
+		callback(handshakeEvent{
+			time:      handshake.Time,
+			handshake: handshake,
+		})
+	})
+	assembler.Verbose = *verbose
+	for _, path := range paths {
+		pcapHandle, err := pcap.OpenOffline(path)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if *verbose {
+			log.Println("Reading JA3(S) hashes from", path)
+		}
+
+		assemblePackets(pcapHandle, assembler, nil)
+		pcapHandle.Close()
+	}
+
+	// There are no more packets coming so finish off any streams still waiting for data
+	assembler.FlushAll()
+	if *verbose {
+		log.Println("Finished reading capture files")
+	}
+}
+
+// uptimeAt returns the system uptime in seconds at a given time, or zero if it isn't known
+func uptimeAt(t time.Time) int64 {
+	if bootTimeErr != nil {
+		return 0
+	}
+	return int64(t.Sub(bootTime).Seconds())
+}
+
+// attributeProcess finds the local process which made the connection or, failing that, the one which accepted it.
+func attributeProcess(handshake ja3assembler.Handshake) *procinfo.Process {
+	lookup := procinfo.LookupTCP
+	if handshake.Transport == ja3assembler.TransportQUIC || handshake.Transport == ja3assembler.TransportDTLS {
+		lookup = procinfo.LookupUDP
+	}
+	if process, err := lookup(handshake.SrcIP, handshake.SrcPort, handshake.DstIP, handshake.DstPort); err == nil {
+		return process
+	}
+	if process, err := lookup(handshake.DstIP, handshake.DstPort, handshake.SrcIP, handshake.SrcPort); err == nil {
+		return process
+	}
+	return nil
+}
+
+// attributeContainer works out which container the connection came from, either from the process that
+// made it or, if that has already gone, from the veth interface it was captured on.
+func attributeContainer(iface string, process *procinfo.Process) *procinfo.Container {
+	if process != nil {
+		return &process.Container
+	}
+	if container, err := procinfo.LookupInterface(iface); err == nil {
+		return container
+	}
+	return nil
+}
+
+// assemblePackets feeds every TCP and UDP packet from the handle into the assembler until the handle runs out of packets.
+// Each time flush fires, connections which have been idle for longer than idleTimeout are finished off.
+func assemblePackets(pcapHandle *pcap.Handle, assembler *ja3assembler.Assembler, flush <-chan time.Time) {
+	// UDP is needed for QUIC and DTLS, which can't be filtered by port as they don't have standard ones
+	err := pcapHandle.SetBPFFilter("tcp or udp")
+	if err != nil {
+		panic(err)
+	}
+
+	packetSource := gopacket.NewPacketSource(pcapHandle, pcapHandle.LinkType())
+	packets := packetSource.Packets()
+
+	for {
+		select {
+		case packet, ok := <-packets:
+			if !ok {
+				// Channel has closed
+				return
+			}
+
+			if packet.NetworkLayer() == nil || packet.TransportLayer() == nil {
+				//Unusable
+				continue
+			}
+			assembler.Assemble(packet)
+
+		case now := <-flush:
+			assembler.FlushOlderThan(now.Add(-idleTimeout))
+		}
+	}
+}
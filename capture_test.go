@@ -0,0 +1,49 @@
+// +build linux
+
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/bradleyjkemp/osquery-ja3/ja3assembler"
+)
+
+func TestAttributeEvents(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	local, remote := conn.LocalAddr().(*net.TCPAddr), conn.RemoteAddr().(*net.TCPAddr)
+
+	pending := make(chan handshakeEvent, 2)
+	pending <- handshakeEvent{handshake: ja3assembler.Handshake{
+		SrcIP: local.IP, SrcPort: uint16(local.Port), DstIP: remote.IP, DstPort: uint16(remote.Port), Transport: ja3assembler.TransportTCP,
+	}}
+	// A connection which isn't on this machine
+	pending <- handshakeEvent{handshake: ja3assembler.Handshake{
+		SrcIP: net.IP{192, 0, 2, 1}, SrcPort: 50000, DstIP: net.IP{192, 0, 2, 2}, DstPort: 443, Transport: ja3assembler.TransportTCP,
+	}}
+	close(pending)
+
+	var attributed []handshakeEvent
+	attributeEvents(pending, func(event handshakeEvent) {
+		attributed = append(attributed, event)
+	})
+	if len(attributed) != 2 {
+		t.Fatalf("got %d events, want 2", len(attributed))
+	}
+	if p := attributed[0].process; p == nil || p.PID != os.Getpid() {
+		t.Errorf("local connection attributed to %+v, want this process", p)
+	}
+	if p := attributed[1].process; p != nil {
+		t.Errorf("remote connection attributed to %+v, want no process", p)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kolide/osquery-go/plugin/table"
+)
+
+// certificateColumns are the columns of the tls_certificates table, which has one row per certificate
+// in the chain sent by the server and is joined to tls_handshake_signatures by eid.
+var certificateColumns = []table.ColumnDefinition{
+	table.BigIntColumn("eid"),
+	table.IntegerColumn("time"),
+	table.IntegerColumn("position"), // 0 is the leaf certificate
+	table.TextColumn("sha1"),
+	table.TextColumn("sha256"),
+	table.TextColumn("ja4x"),
+	table.TextColumn("subject"),
+	table.TextColumn("issuer"),
+	table.TextColumn("san"),
+	table.IntegerColumn("not_valid_before"),
+	table.IntegerColumn("not_valid_after"),
+	table.TextColumn("key_algorithm"),
+	table.IntegerColumn("key_strength"),
+	table.IntegerColumn("self_signed"),
+	table.IntegerColumn("expired"), // whether the certificate had expired when the handshake happened
+}
+
+func generateCertificatesTable(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	rows := []map[string]string{}
+	for _, event := range readEvents(queryContext, certificatesTable) {
+		for i, cert := range event.handshake.Certificates {
+			row := map[string]string{
+				"eid":           fmt.Sprint(event.eid),
+				"time":          fmt.Sprint(event.time.Unix()),
+				"position":      fmt.Sprint(i),
+				"sha1":          cert.SHA1,
+				"sha256":        cert.SHA256,
+				"ja4x":          cert.JA4X,
+				"subject":       cert.Subject,
+				"issuer":        cert.Issuer,
+				"san":           strings.Join(cert.SANs, ","),
+				"key_algorithm": cert.KeyType,
+				"self_signed":   boolColumn(cert.SelfSigned),
+			}
+			if !cert.NotAfter.IsZero() {
+				row["not_valid_before"] = fmt.Sprint(cert.NotBefore.Unix())
+				row["not_valid_after"] = fmt.Sprint(cert.NotAfter.Unix())
+				row["expired"] = boolColumn(event.time.After(cert.NotAfter))
+			}
+			if cert.KeyBits != 0 {
+				row["key_strength"] = fmt.Sprint(cert.KeyBits)
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+func hasCertificates(event *handshakeEvent) bool {
+	return len(event.handshake.Certificates) > 0
+}
+
+// boolColumn formats a boolean the way osquery does
+func boolColumn(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
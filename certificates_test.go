@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bradleyjkemp/osquery-ja3/ja3assembler"
+	"github.com/kolide/osquery-go/plugin/table"
+)
+
+func TestGenerateCertificatesTable(t *testing.T) {
+	s := newEventStore(0, 0, 0)
+	withEvents(t, s)
+
+	event := testEvent(0, "a")
+	event.handshake.Certificates = []ja3assembler.Certificate{
+		{
+			SHA1:       "leaf-sha1",
+			SHA256:     "leaf-sha256",
+			JA4X:       "leaf-ja4x",
+			Subject:    "CN=example.com",
+			Issuer:     "CN=Example CA",
+			SANs:       []string{"example.com", "192.0.2.1"},
+			NotBefore:  testStart.AddDate(-1, 0, 0),
+			NotAfter:   testStart.Add(-time.Second),
+			KeyType:    "ECDSA",
+			KeyBits:    256,
+			SelfSigned: false,
+		},
+		{
+			SHA1:       "ca-sha1",
+			SHA256:     "ca-sha256",
+			JA4X:       "ca-ja4x",
+			Subject:    "CN=Example CA",
+			Issuer:     "CN=Example CA",
+			NotBefore:  testStart.AddDate(-1, 0, 0),
+			NotAfter:   testStart.AddDate(1, 0, 0),
+			KeyType:    "RSA",
+			KeyBits:    2048,
+			SelfSigned: true,
+		},
+		// Couldn't be parsed so only the fingerprints are known
+		{SHA1: "bad-sha1", SHA256: "bad-sha256", JA4X: "bad-ja4x"},
+	}
+	s.add(event)
+	s.add(testEvent(1, "b")) // has no certificates so has no rows
+
+	rows, err := generateCertificatesTable(context.Background(), table.QueryContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eid, unix := fmt.Sprint(event.eid), fmt.Sprint(testStart.Unix())
+	want := []map[string]string{
+		{
+			"eid": eid, "time": unix, "position": "0",
+			"sha1": "leaf-sha1", "sha256": "leaf-sha256", "ja4x": "leaf-ja4x",
+			"subject": "CN=example.com", "issuer": "CN=Example CA", "san": "example.com,192.0.2.1",
+			"not_valid_before": fmt.Sprint(testStart.AddDate(-1, 0, 0).Unix()),
+			"not_valid_after":  fmt.Sprint(testStart.Unix() - 1),
+			"key_algorithm":    "ECDSA", "key_strength": "256",
+			"self_signed": "0", "expired": "1",
+		},
+		{
+			"eid": eid, "time": unix, "position": "1",
+			"sha1": "ca-sha1", "sha256": "ca-sha256", "ja4x": "ca-ja4x",
+			"subject": "CN=Example CA", "issuer": "CN=Example CA", "san": "",
+			"not_valid_before": fmt.Sprint(testStart.AddDate(-1, 0, 0).Unix()),
+			"not_valid_after":  fmt.Sprint(testStart.AddDate(1, 0, 0).Unix()),
+			"key_algorithm":    "RSA", "key_strength": "2048",
+			"self_signed": "1", "expired": "0",
+		},
+		{
+			"eid": eid, "time": unix, "position": "2",
+			"sha1": "bad-sha1", "sha256": "bad-sha256", "ja4x": "bad-ja4x",
+			"subject": "", "issuer": "", "san": "",
+			"key_algorithm": "",
+			"self_signed":   "0",
+		},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %v, want %v", rows, want)
+	}
+}
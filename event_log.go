@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bradleyjkemp/osquery-ja3/ja3assembler"
+	"github.com/bradleyjkemp/osquery-ja3/procinfo"
+)
+
+// minCompactionRecords stops small logs from being compacted after every event
+const minCompactionRecords = 1000
+
+// eventLog persists events to an append-only file of JSON lines so that they survive restarts.
+// Events removed from the store stay in the file until it is compacted by rewriting it with only the
+// events still in the store, so the file's size is bounded by the store's limits.
+// The tables which have returned an event with --expire_on_read are recorded by appending a read record.
+type eventLog struct {
+	path    string
+	file    *os.File
+	records int    // number of records in the file, including those of events no longer in the store
+	nextEID uint64 // the eid after the last one in the file, so that eids carry on increasing after a restart
+}
+
+// persistedEvent is the on-disk form of a handshakeEvent
+type persistedEvent struct {
+	EID       uint64                 `json:"eid"`
+	Time      time.Time              `json:"time"`
+	Uptime    int64                  `json:"uptime,omitempty"`
+	Interface string                 `json:"interface,omitempty"`
+	Process   *procinfo.Process      `json:"process,omitempty"`
+	Container *procinfo.Container    `json:"container,omitempty"`
+	Handshake ja3assembler.Handshake `json:"handshake"`
+	Read      eventTable             `json:"read,omitempty"`
+
+	// Only set in the first record of a compacted file, which has no event
+	NextEID uint64 `json:"next_eid,omitempty"`
+}
+
+// persistedNextEID is the first record of a compacted file, so that eids aren't reused if the newest events were removed
+type persistedNextEID struct {
+	NextEID uint64 `json:"next_eid"`
+}
+
+// persistedRead records that an event has been returned by more tables since it was appended to the log
+type persistedRead struct {
+	EID  uint64     `json:"eid"`
+	Read eventTable `json:"read"`
+}
+
+// openEventLog opens the log at path, creating it if necessary, and returns the events already in it.
+func openEventLog(path string) (*eventLog, []*handshakeEvent, error) {
+	l := &eventLog{path: path}
+	events, err := l.read()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	l.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return l, events, nil
+}
+
+// read returns the events in the file, counting its records and finding the next eid as it goes.
+func (l *eventLog) read() ([]*handshakeEvent, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	events := []*handshakeEvent{}
+	byEID := map[uint64]*handshakeEvent{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		persisted := persistedEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &persisted); err != nil {
+			// Most likely the last line was only partially written before a crash
+			log.Printf("Skipping unreadable event in %s: %v", l.path, err)
+			continue
+		}
+		l.records++
+
+		switch {
+		case persisted.NextEID != 0:
+			if persisted.NextEID > l.nextEID {
+				l.nextEID = persisted.NextEID
+			}
+			continue
+		case persisted.Time.IsZero():
+			// A read record
+			if event := byEID[persisted.EID]; event != nil {
+				event.read |= persisted.Read
+			}
+			continue
+		}
+
+		event := &handshakeEvent{
+			eid:       persisted.EID,
+			time:      persisted.Time,
+			uptime:    persisted.Uptime,
+			iface:     persisted.Interface,
+			process:   persisted.Process,
+			container: persisted.Container,
+			handshake: persisted.Handshake,
+			read:      persisted.Read,
+		}
+		events = append(events, event)
+		byEID[event.eid] = event
+		if event.eid >= l.nextEID {
+			l.nextEID = event.eid + 1
+		}
+	}
+	return events, scanner.Err()
+}
+
+func marshalEvent(event *handshakeEvent) ([]byte, error) {
+	line, err := json.Marshal(persistedEvent{
+		EID:       event.eid,
+		Time:      event.time,
+		Uptime:    event.uptime,
+		Interface: event.iface,
+		Process:   event.process,
+		Container: event.container,
+		Handshake: event.handshake,
+		Read:      event.read,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+func (l *eventLog) append(event *handshakeEvent) error {
+	line, err := marshalEvent(event)
+	if err != nil {
+		return err
+	}
+	return l.write(line)
+}
+
+// appendRead records which tables have returned an event.
+func (l *eventLog) appendRead(event *handshakeEvent) error {
+	line, err := json.Marshal(persistedRead{EID: event.eid, Read: event.read})
+	if err != nil {
+		return err
+	}
+	return l.write(append(line, '\n'))
+}
+
+func (l *eventLog) write(line []byte) error {
+	if _, err := l.file.Write(line); err != nil {
+		return err
+	}
+	l.records++
+	return nil
+}
+
+// needsCompaction returns whether most of the file is taken up by records of events that have been removed from the store
+func (l *eventLog) needsCompaction(storedEvents int) bool {
+	return l.records > 2*storedEvents+minCompactionRecords
+}
+
+// compact replaces the file with one containing only the given events, preceded by the next eid.
+// The new file is written alongside the old one and renamed over it so that a crash part way through doesn't lose anything.
+func (l *eventLog) compact(events []*handshakeEvent, nextEID uint64) error {
+	tmpPath := l.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath) // no-op once the file has been renamed
+
+	writer := bufio.NewWriter(tmp)
+	line, err := json.Marshal(persistedNextEID{NextEID: nextEID})
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	writer.Write(append(line, '\n'))
+	for _, event := range events {
+		line, err := marshalEvent(event)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		writer.Write(line)
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		return err
+	}
+	l.file.Close()
+	l.file, err = os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to reopen compacted event log: %v", err)
+	}
+	l.records = 1 + len(events)
+	l.nextEID = nextEID
+	return nil
+}
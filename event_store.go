@@ -0,0 +1,374 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kolide/osquery-go/plugin/table"
+)
+
+// indexedColumns are the columns which the eventStore indexes so that equality constraints on them are cheap.
+var indexedColumns = map[string]func(*handshakeEvent) string{
+	"ja3":  func(event *handshakeEvent) string { return event.handshake.JA3 },
+	"ja3s": func(event *handshakeEvent) string { return event.handshake.JA3S },
+	"sni":  func(event *handshakeEvent) string { return event.handshake.SNI },
+}
+
+// eventStore holds handshake events sorted by time along with indexes of the indexedColumns.
+// Events are kept until they're older than the retention period or, if the store is full, until
+// they're the oldest event and space is needed for a new one.
+type eventStore struct {
+	sync.Mutex
+	events  []*handshakeEvent
+	indexes map[string]map[string]*indexedEvents // column -> value -> events with that value
+	bytes   int                                  // approximate memory used by the events
+	log     *eventLog                            // optional on-disk copy of the events
+	nextEID uint64
+
+	retention time.Duration // zero means events never expire
+	maxEvents int           // zero means no limit
+	maxBytes  int           // zero means no limit
+
+	// Counts of removed events by the reason they were removed
+	expired          uint64
+	droppedMaxEvents uint64
+	droppedMaxBytes  uint64
+}
+
+func newEventStore(retention time.Duration, maxEvents, maxBytes int) *eventStore {
+	s := &eventStore{
+		indexes:   map[string]map[string]*indexedEvents{},
+		retention: retention,
+		maxEvents: maxEvents,
+		maxBytes:  maxBytes,
+	}
+	for column := range indexedColumns {
+		s.indexes[column] = map[string]*indexedEvents{}
+	}
+	return s
+}
+
+// indexedEvents are the events with a particular value of an indexed column, in insertion order. Removing an
+// event from the middle would mean copying the rest so removed events are left in place and only dropped once
+// they're at the front or make up over half of the slice, keeping removal amortised O(1).
+type indexedEvents struct {
+	events  []*handshakeEvent
+	removed int // the number of events which have been removed from the store but are still in events
+}
+
+// live returns the number of events which haven't been removed.
+func (idx *indexedEvents) live() int {
+	return len(idx.events) - idx.removed
+}
+
+// compact drops every removed event.
+func (idx *indexedEvents) compact() {
+	kept := idx.events[:0]
+	for _, event := range idx.events {
+		if !event.removed {
+			kept = append(kept, event)
+		}
+	}
+	for i := len(kept); i < len(idx.events); i++ {
+		idx.events[i] = nil // allow the removed events to be garbage collected
+	}
+	idx.events = kept
+	idx.removed = 0
+}
+
+// add stores an event, evicting the oldest events if the store is full.
+func (s *eventStore) add(event *handshakeEvent) {
+	event.eid = s.nextEID
+	s.nextEID++
+	s.insert(event)
+	s.evict()
+
+	if s.log != nil {
+		if err := s.log.append(event); err != nil {
+			log.Printf("Failed to persist event: %v", err)
+		}
+		s.maybeCompactLog()
+	}
+}
+
+// attachLog loads the events from an event log and then persists all further events to it.
+func (s *eventStore) attachLog(l *eventLog, persisted []*handshakeEvent) {
+	for _, event := range persisted {
+		if event.read == event.tables() {
+			// Every table showing the event returned it before the restart
+			continue
+		}
+		s.insert(event)
+	}
+	if l.nextEID > s.nextEID {
+		s.nextEID = l.nextEID
+	}
+	s.expire(time.Now())
+	s.evict()
+
+	s.log = l
+	s.maybeCompactLog()
+}
+
+// evict removes the oldest events until the store is within its limits.
+func (s *eventStore) evict() {
+	for s.maxEvents > 0 && len(s.events) > s.maxEvents {
+		s.removeOldest(1)
+		s.droppedMaxEvents++
+	}
+	for s.maxBytes > 0 && s.bytes > s.maxBytes && len(s.events) > 0 {
+		s.removeOldest(1)
+		s.droppedMaxBytes++
+	}
+}
+
+// insert adds an event in time order. Events normally arrive in time order but can be slightly
+// out of order when captured on multiple interfaces so this can't just append.
+func (s *eventStore) insert(event *handshakeEvent) {
+	i := sort.Search(len(s.events), func(i int) bool { return s.events[i].time.After(event.time) })
+	s.events = append(s.events, nil)
+	copy(s.events[i+1:], s.events[i:])
+	s.events[i] = event
+
+	for column, value := range indexedColumns {
+		v := value(event)
+		idx := s.indexes[column][v]
+		if idx == nil {
+			idx = &indexedEvents{}
+			s.indexes[column][v] = idx
+		}
+		idx.events = append(idx.events, event)
+	}
+	s.bytes += event.size()
+}
+
+// expire removes all events older than the retention period.
+func (s *eventStore) expire(now time.Time) {
+	if s.retention == 0 {
+		return
+	}
+
+	cutoff := now.Add(-s.retention)
+	n := sort.Search(len(s.events), func(i int) bool { return !s.events[i].time.Before(cutoff) })
+	s.removeOldest(n)
+	s.expired += uint64(n)
+
+	if n > 0 && s.log != nil {
+		s.maybeCompactLog()
+	}
+}
+
+func (s *eventStore) maybeCompactLog() {
+	if !s.log.needsCompaction(len(s.events)) {
+		return
+	}
+	if err := s.log.compact(s.events, s.nextEID); err != nil {
+		log.Printf("Failed to compact event log: %v", err)
+	}
+}
+
+// markRead records that a table has returned events, removing those which every table showing them now has.
+func (s *eventStore) markRead(read []*handshakeEvent, from eventTable) {
+	removed := []*handshakeEvent{}
+	for _, event := range read {
+		event.read |= from
+		if event.read == event.tables() {
+			removed = append(removed, event)
+		}
+		if s.log != nil {
+			// So that the event isn't returned again after a restart
+			if err := s.log.appendRead(event); err != nil {
+				log.Printf("Failed to persist read event: %v", err)
+			}
+		}
+	}
+	s.remove(removed)
+	if s.log != nil {
+		s.maybeCompactLog()
+	}
+}
+
+// remove removes specific events from the store.
+func (s *eventStore) remove(removed []*handshakeEvent) {
+	if len(removed) == 0 {
+		return
+	}
+
+	toRemove := map[*handshakeEvent]bool{}
+	for _, event := range removed {
+		toRemove[event] = true
+	}
+
+	kept := s.events[:0]
+	for _, event := range s.events {
+		if !toRemove[event] {
+			kept = append(kept, event)
+			continue
+		}
+		s.unindex(event)
+		s.bytes -= event.size()
+	}
+	for i := len(kept); i < len(s.events); i++ {
+		s.events[i] = nil // allow the removed events to be garbage collected
+	}
+	s.events = kept
+}
+
+// removeOldest removes the n oldest events.
+func (s *eventStore) removeOldest(n int) {
+	for i, event := range s.events[:n] {
+		s.unindex(event)
+		s.bytes -= event.size()
+		s.events[i] = nil // allow the event to be garbage collected before the slice is reallocated
+	}
+	s.events = s.events[n:]
+}
+
+func (s *eventStore) unindex(event *handshakeEvent) {
+	event.removed = true
+	for column, value := range indexedColumns {
+		v := value(event)
+		idx := s.indexes[column][v]
+		idx.removed++
+		if idx.live() == 0 {
+			delete(s.indexes[column], v)
+			continue
+		}
+
+		// The oldest events are the ones expired so this is by far the most common case
+		for idx.events[0].removed {
+			idx.events[0] = nil
+			idx.events = idx.events[1:]
+			idx.removed--
+		}
+		if idx.removed > len(idx.events)/2 {
+			idx.compact()
+		}
+	}
+}
+
+// eventQuery is the subset of a query's constraints that the eventStore can use to avoid returning every event.
+// osquery applies all the constraints again itself so any that can't be used are simply ignored.
+type eventQuery struct {
+	from, to   int64             // inclusive range of unix times
+	equals     map[string]string // required values of indexedColumns
+	impossible bool              // set if the constraints contradict each other so nothing can match
+}
+
+func parseEventQuery(queryContext table.QueryContext) eventQuery {
+	q := eventQuery{from: math.MinInt64, to: math.MaxInt64, equals: map[string]string{}}
+
+	for _, constraint := range queryContext.Constraints["time"].Constraints {
+		t, err := strconv.ParseInt(constraint.Expression, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch constraint.Operator {
+		case table.OperatorEquals:
+			q.from, q.to = maxInt64(q.from, t), minInt64(q.to, t)
+		case table.OperatorGreaterThan:
+			if t == math.MaxInt64 {
+				// Nothing is later, and t+1 would overflow
+				q.impossible = true
+				continue
+			}
+			q.from = maxInt64(q.from, t+1)
+		case table.OperatorGreaterThanOrEquals:
+			q.from = maxInt64(q.from, t)
+		case table.OperatorLessThan:
+			if t == math.MinInt64 {
+				q.impossible = true
+				continue
+			}
+			q.to = minInt64(q.to, t-1)
+		case table.OperatorLessThanOrEquals:
+			q.to = minInt64(q.to, t)
+		}
+	}
+	if q.from > q.to {
+		q.impossible = true
+	}
+
+	for column := range indexedColumns {
+		for _, constraint := range queryContext.Constraints[column].Constraints {
+			if constraint.Operator != table.OperatorEquals {
+				continue
+			}
+			if existing, ok := q.equals[column]; ok && existing != constraint.Expression {
+				q.impossible = true
+			}
+			q.equals[column] = constraint.Expression
+		}
+	}
+	return q
+}
+
+// query returns the events which match the query.
+func (s *eventStore) query(q eventQuery) []*handshakeEvent {
+	if q.impossible {
+		return nil
+	}
+
+	matches := func(event *handshakeEvent) bool {
+		if event.removed {
+			// Still in an index until it's compacted
+			return false
+		}
+		t := event.time.Unix()
+		if t < q.from || t > q.to {
+			return false
+		}
+		for column, required := range q.equals {
+			if indexedColumns[column](event) != required {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Start from the smallest matching index, or otherwise the events within the time range
+	var candidates []*handshakeEvent
+	if len(q.equals) > 0 {
+		smallest := -1
+		for column, required := range q.equals {
+			idx := s.indexes[column][required]
+			if idx == nil {
+				return []*handshakeEvent{}
+			}
+			if smallest < 0 || idx.live() < smallest {
+				candidates = idx.events
+				smallest = idx.live()
+			}
+		}
+	} else {
+		start := sort.Search(len(s.events), func(i int) bool { return s.events[i].time.Unix() >= q.from })
+		end := sort.Search(len(s.events), func(i int) bool { return s.events[i].time.Unix() > q.to })
+		candidates = s.events[start:end]
+	}
+
+	results := []*handshakeEvent{}
+	for _, event := range candidates {
+		if matches(event) {
+			results = append(results, event)
+		}
+	}
+	return results
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bradleyjkemp/osquery-ja3/ja3assembler"
+	"github.com/kolide/osquery-go/plugin/table"
+)
+
+var testStart = time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+
+// testEvent returns a TLS event seen the given number of seconds after testStart.
+func testEvent(seconds int, ja3 string) *handshakeEvent {
+	return &handshakeEvent{
+		time:      testStart.Add(time.Duration(seconds) * time.Second),
+		handshake: ja3assembler.Handshake{JA3: ja3, SNI: "example.com"},
+	}
+}
+
+func eids(events []*handshakeEvent) []uint64 {
+	ids := []uint64{}
+	for _, event := range events {
+		ids = append(ids, event.eid)
+	}
+	return ids
+}
+
+func constraint(operator table.Operator, expression string) table.ConstraintList {
+	return table.ConstraintList{Constraints: []table.Constraint{{Operator: operator, Expression: expression}}}
+}
+
+func TestEventStoreEvict(t *testing.T) {
+	s := newEventStore(0, 2, 0)
+	for i := 0; i < 3; i++ {
+		s.add(testEvent(i, "a"))
+	}
+	if got := eids(s.events); !reflect.DeepEqual(got, []uint64{1, 2}) {
+		t.Errorf("events = %v, want [1 2]", got)
+	}
+	if s.droppedMaxEvents != 1 {
+		t.Errorf("droppedMaxEvents = %d, want 1", s.droppedMaxEvents)
+	}
+	if got := eids(s.indexes["ja3"]["a"].events); !reflect.DeepEqual(got, []uint64{1, 2}) {
+		t.Errorf("ja3 index = %v, want [1 2]", got)
+	}
+
+	s = newEventStore(0, 0, 2*testEvent(0, "a").size())
+	for i := 0; i < 3; i++ {
+		s.add(testEvent(i, "a"))
+	}
+	if got := eids(s.events); !reflect.DeepEqual(got, []uint64{1, 2}) {
+		t.Errorf("events = %v, want [1 2]", got)
+	}
+	if s.droppedMaxBytes != 1 {
+		t.Errorf("droppedMaxBytes = %d, want 1", s.droppedMaxBytes)
+	}
+}
+
+func TestEventStoreExpire(t *testing.T) {
+	s := newEventStore(time.Minute, 0, 0)
+	s.add(testEvent(0, "a"))
+	s.add(testEvent(30, "b"))
+	s.add(testEvent(90, "c"))
+
+	s.expire(testStart.Add(100 * time.Second))
+	if got := eids(s.events); !reflect.DeepEqual(got, []uint64{2}) {
+		t.Errorf("events = %v, want [2]", got)
+	}
+	if s.expired != 2 {
+		t.Errorf("expired = %d, want 2", s.expired)
+	}
+	if _, ok := s.indexes["ja3"]["a"]; ok {
+		t.Error("expired event is still indexed")
+	}
+}
+
+func TestEventStoreInsertOutOfOrder(t *testing.T) {
+	s := newEventStore(0, 0, 0)
+	s.add(testEvent(2, "a"))
+	s.add(testEvent(0, "b"))
+	s.add(testEvent(1, "c"))
+	if got := eids(s.events); !reflect.DeepEqual(got, []uint64{1, 2, 0}) {
+		t.Errorf("events = %v, want them in time order [1 2 0]", got)
+	}
+}
+
+func TestEventStoreRemove(t *testing.T) {
+	s := newEventStore(0, 0, 0)
+	for i := 0; i < 8; i++ {
+		s.add(testEvent(i, "a"))
+	}
+	index := s.indexes["ja3"]["a"]
+	ja3 := table.QueryContext{Constraints: map[string]table.ConstraintList{"ja3": constraint(table.OperatorEquals, "a")}}
+
+	// Removed events in the middle of the index are left there until over half of it has been removed
+	s.remove([]*handshakeEvent{s.events[2], s.events[5]})
+	if got := eids(index.events); len(got) != 8 || index.removed != 2 {
+		t.Errorf("ja3 index = %v with %d removed, want all 8 with 2 removed", got, index.removed)
+	}
+	if got := eids(s.query(parseEventQuery(ja3))); !reflect.DeepEqual(got, []uint64{0, 1, 3, 4, 6, 7}) {
+		t.Errorf("query returned %v, want [0 1 3 4 6 7]", got)
+	}
+
+	// Removing the oldest event also drops the removed events which are now at the front
+	s.remove([]*handshakeEvent{s.events[0], s.events[1]})
+	if got := eids(index.events); !reflect.DeepEqual(got, []uint64{3, 4, 5, 6, 7}) || index.removed != 1 {
+		t.Errorf("ja3 index = %v with %d removed, want [3 4 5 6 7] with 1 removed", got, index.removed)
+	}
+
+	s.remove([]*handshakeEvent{s.events[1], s.events[3]})
+	if got := eids(index.events); !reflect.DeepEqual(got, []uint64{3, 6}) || index.removed != 0 {
+		t.Errorf("ja3 index = %v with %d removed, want it compacted to [3 6]", got, index.removed)
+	}
+	if got := eids(s.query(parseEventQuery(ja3))); !reflect.DeepEqual(got, []uint64{3, 6}) {
+		t.Errorf("query returned %v, want [3 6]", got)
+	}
+
+	s.remove(s.events)
+	if len(s.indexes["ja3"]) != 0 {
+		t.Errorf("ja3 index still has %d values", len(s.indexes["ja3"]))
+	}
+}
+
+func TestEventStoreQuery(t *testing.T) {
+	s := newEventStore(0, 0, 0)
+	for i, ja3 := range []string{"a", "b", "a", "b", "a"} {
+		s.add(testEvent(i, ja3))
+	}
+	unix := func(seconds int) string {
+		return strconv.FormatInt(testStart.Unix()+int64(seconds), 10)
+	}
+
+	tests := []struct {
+		name        string
+		constraints map[string]table.ConstraintList
+		want        []uint64
+	}{
+		{"all", nil, []uint64{0, 1, 2, 3, 4}},
+		{"time =", map[string]table.ConstraintList{"time": constraint(table.OperatorEquals, unix(1))}, []uint64{1}},
+		{"time >", map[string]table.ConstraintList{"time": constraint(table.OperatorGreaterThan, unix(2))}, []uint64{3, 4}},
+		{"time >=", map[string]table.ConstraintList{"time": constraint(table.OperatorGreaterThanOrEquals, unix(2))}, []uint64{2, 3, 4}},
+		{"time <", map[string]table.ConstraintList{"time": constraint(table.OperatorLessThan, unix(2))}, []uint64{0, 1}},
+		{"time <=", map[string]table.ConstraintList{"time": constraint(table.OperatorLessThanOrEquals, unix(2))}, []uint64{0, 1, 2}},
+		{"time range", map[string]table.ConstraintList{"time": {Constraints: []table.Constraint{
+			{Operator: table.OperatorGreaterThan, Expression: unix(0)},
+			{Operator: table.OperatorLessThan, Expression: unix(4)},
+		}}}, []uint64{1, 2, 3}},
+		{"contradictory", map[string]table.ConstraintList{"time": {Constraints: []table.Constraint{
+			{Operator: table.OperatorGreaterThan, Expression: unix(3)},
+			{Operator: table.OperatorLessThan, Expression: unix(1)},
+		}}}, []uint64{}},
+		{"ja3", map[string]table.ConstraintList{"ja3": constraint(table.OperatorEquals, "a")}, []uint64{0, 2, 4}},
+		{"ja3 and time", map[string]table.ConstraintList{
+			"ja3":  constraint(table.OperatorEquals, "b"),
+			"time": constraint(table.OperatorGreaterThan, unix(1)),
+		}, []uint64{3}},
+		{"ja3 and sni", map[string]table.ConstraintList{
+			"ja3": constraint(table.OperatorEquals, "a"),
+			"sni": constraint(table.OperatorEquals, "example.org"),
+		}, []uint64{}},
+		{"time > max", map[string]table.ConstraintList{"time": constraint(table.OperatorGreaterThan, "9223372036854775807")}, []uint64{}},
+		{"time < min", map[string]table.ConstraintList{"time": constraint(table.OperatorLessThan, "-9223372036854775808")}, []uint64{}},
+		{"time >= max", map[string]table.ConstraintList{"time": constraint(table.OperatorGreaterThanOrEquals, "9223372036854775807")}, []uint64{}},
+		{"time <= max", map[string]table.ConstraintList{"time": constraint(table.OperatorLessThanOrEquals, "9223372036854775807")}, []uint64{0, 1, 2, 3, 4}},
+		{"unindexed", map[string]table.ConstraintList{"ja3": constraint(table.OperatorLike, "a%")}, []uint64{0, 1, 2, 3, 4}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := eids(s.query(parseEventQuery(table.QueryContext{Constraints: test.constraints})))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("query returned %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// withEvents replaces the global event store and turns on --expire_on_read for the duration of a test.
+func withEvents(t *testing.T, s *eventStore) {
+	oldEvents, oldExpireOnRead := events, *fExpireOnRead
+	events, *fExpireOnRead = s, true
+	t.Cleanup(func() {
+		events, *fExpireOnRead = oldEvents, oldExpireOnRead
+	})
+}
+
+func TestExpireOnRead(t *testing.T) {
+	s := newEventStore(0, 0, 0)
+	withEvents(t, s)
+
+	withCertificate := testEvent(0, "a")
+	withCertificate.handshake.Certificates = []ja3assembler.Certificate{{SHA1: "sha1"}}
+	s.add(withCertificate)
+	s.add(testEvent(1, "b"))
+	ssh := testEvent(2, "")
+	ssh.handshake.SSH = &ja3assembler.SSHHandshake{}
+	s.add(ssh)
+
+	read := func(from eventTable) []uint64 {
+		return eids(readEvents(table.QueryContext{}, from))
+	}
+	if got := read(handshakesTable); !reflect.DeepEqual(got, []uint64{0, 1}) {
+		t.Errorf("handshakes = %v, want [0 1]", got)
+	}
+	if got := read(handshakesTable); len(got) != 0 {
+		t.Errorf("handshakes read again = %v, want none", got)
+	}
+	// The event with a certificate must still be there to be joined with its certificates
+	if got := eids(s.events); !reflect.DeepEqual(got, []uint64{0, 2}) {
+		t.Errorf("stored events = %v, want [0 2]", got)
+	}
+
+	if got := read(certificatesTable); !reflect.DeepEqual(got, []uint64{0}) {
+		t.Errorf("certificates = %v, want [0]", got)
+	}
+	if got := read(sshTable); !reflect.DeepEqual(got, []uint64{2}) {
+		t.Errorf("ssh = %v, want [2]", got)
+	}
+	if len(s.events) != 0 {
+		t.Errorf("stored events = %v, want none", eids(s.events))
+	}
+	for column, index := range s.indexes {
+		if len(index) != 0 {
+			t.Errorf("%s index still has %d values", column, len(index))
+		}
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+
+	// open attaches a store to the log at path, like main does
+	open := func() *eventStore {
+		l, persisted, err := openEventLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.file.Close() })
+		s := newEventStore(0, 0, 0)
+		s.attachLog(l, persisted)
+		return s
+	}
+
+	s := open()
+	withEvents(t, s)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		s.add(&handshakeEvent{time: now.Add(time.Duration(i) * time.Second), handshake: ja3assembler.Handshake{JA3: "a"}})
+	}
+	readEvents(table.QueryContext{Constraints: map[string]table.ConstraintList{
+		"time": constraint(table.OperatorLessThanOrEquals, strconv.FormatInt(now.Unix(), 10)),
+	}}, handshakesTable)
+	s.log.file.Close()
+
+	s = open()
+	withEvents(t, s)
+	if got := eids(s.events); !reflect.DeepEqual(got, []uint64{1, 2}) {
+		t.Fatalf("reloaded events = %v, want [1 2]", got)
+	}
+	if s.log.records != 4 {
+		t.Errorf("records = %d, want 3 events and 1 read record", s.log.records)
+	}
+
+	// Removing the newest event mustn't let its eid be reused after compaction and a restart
+	readEvents(table.QueryContext{}, handshakesTable)
+	if err := s.log.compact(s.events, s.nextEID); err != nil {
+		t.Fatal(err)
+	}
+	if s.log.records != 1 {
+		t.Errorf("records after compaction = %d, want just the next eid", s.log.records)
+	}
+	s.log.file.Close()
+
+	s = open()
+	if len(s.events) != 0 {
+		t.Errorf("reloaded events = %v, want none", eids(s.events))
+	}
+	s.add(testEvent(0, "a"))
+	if got := s.events[0].eid; got != 3 {
+		t.Errorf("eid after restart = %d, want 3", got)
+	}
+}
+
+func TestEventLogCompaction(t *testing.T) {
+	l := &eventLog{}
+	if l.needsCompaction(0) {
+		t.Error("empty log needs compaction")
+	}
+	l.records = 2*100 + minCompactionRecords
+	if l.needsCompaction(100) {
+		t.Error("log needs compaction before it's more than twice the stored events")
+	}
+	l.records++
+	if !l.needsCompaction(100) {
+		t.Error("log doesn't need compaction once it's more than twice the stored events")
+	}
+}
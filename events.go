@@ -3,63 +3,338 @@ package main
 import (
 	"context"
 	"fmt"
-	"sync"
+	"syscall"
 	"time"
 
+	"github.com/bradleyjkemp/osquery-ja3/ja3assembler"
+	"github.com/bradleyjkemp/osquery-ja3/procinfo"
 	"github.com/kolide/osquery-go/plugin/table"
 )
 
-var eventsLock sync.Mutex
-var events []handshakeEvent
+// events is created in main once the flags configuring its limits have been parsed
+var events *eventStore
+
+// eventOverhead is a rough estimate of the memory used by an event not counting the contents of
+// its strings, including its entries in the eventStore's slice and indexes.
+const eventOverhead = 512
+
+// certificateOverhead is the equivalent of eventOverhead for each certificate in an event
+const certificateOverhead = 128
+
+// handshakeColumns are the columns of the tls_handshake_signatures table, in the order they're displayed.
+var handshakeColumns = eventColumns(
+	table.TextColumn("ja3"),
+	table.TextColumn("ja3_string"),
+	table.TextColumn("ja3s"),
+	table.TextColumn("ja3s_string"),
+	table.TextColumn("ja4"),
+	table.TextColumn("ja4_r"),
+	table.TextColumn("ja4s"),
+	table.TextColumn("sni"),
+	table.TextColumn("protocol"),
+	table.BigIntColumn("hello_latency_us"),
+	table.BigIntColumn("handshake_duration_us"),
+)
+
+// eventColumns returns the columns of a table of events: when the event happened,
+// the columns specific to the table, then the details of the connection.
+func eventColumns(columns ...table.ColumnDefinition) []table.ColumnDefinition {
+	all := []table.ColumnDefinition{
+		table.IntegerColumn("time"),
+		table.BigIntColumn("uptime"),
+		table.BigIntColumn("eid"),
+	}
+	all = append(all, columns...)
+	return append(all,
+		table.TextColumn("src_ip"),
+		table.IntegerColumn("src_port"),
+		table.TextColumn("dst_ip"),
+		table.IntegerColumn("dst_port"),
+		table.IntegerColumn("family"),
+		table.TextColumn("transport"),
+		table.TextColumn("interface"),
+		table.TextColumn("ja4t"),
+		table.IntegerColumn("client_ttl"),
+		table.TextColumn("ja4ts"),
+		table.IntegerColumn("server_ttl"),
+		table.BigIntColumn("syn_to_synack_us"),
+		table.BigIntColumn("synack_to_ack_us"),
+		table.TextColumn("ja4l"),
+		table.TextColumn("ja4ls"),
+		table.IntegerColumn("pid"),
+		table.TextColumn("process_name"),
+		table.TextColumn("path"),
+		table.IntegerColumn("uid"),
+		table.TextColumn("cmdline"),
+		table.TextColumn("cgroup"),
+		table.TextColumn("container_id"),
+		table.TextColumn("pod_uid"),
+		table.BigIntColumn("net_namespace"),
+	)
+}
+
+// eventTable is a bit identifying one of the tables which show stored events.
+type eventTable uint8
+
+const (
+	handshakesTable eventTable = 1 << iota
+	certificatesTable
+	sshTable
+	httpTable
+	http2Table
+)
+
+// eventTables says which events each table shows. With --expire_on_read, each table returns an event
+// once and the event is removed when every table showing it has, so that the tables can still be joined.
+var eventTables = map[eventTable]func(*handshakeEvent) bool{
+	handshakesTable:   isTLSEvent,
+	certificatesTable: hasCertificates,
+	sshTable:          isSSHEvent,
+	httpTable:         isHTTPEvent,
+	http2Table:        isHTTP2Event,
+}
 
 type handshakeEvent struct {
-	time           time.Time
-	ja3, ja3s, sni string
+	eid       uint64              // assigned by the eventStore, increases with every event stored
+	time      time.Time           // when the handshake happened
+	uptime    int64               // system uptime in seconds when the handshake happened, zero if unknown
+	iface     string              // interface the handshake was captured on, empty if read from a file
+	process   *procinfo.Process   // local process which owned the connection, if known
+	container *procinfo.Container // container the connection came from, if known
+	handshake ja3assembler.Handshake
+	read      eventTable // the tables which have returned the event with --expire_on_read
+	removed   bool       // set once the eventStore has removed the event
+}
+
+// tables returns the tables which show the event.
+func (e *handshakeEvent) tables() eventTable {
+	var tables eventTable
+	for t, shows := range eventTables {
+		if shows(e) {
+			tables |= t
+		}
+	}
+	return tables
 }
 
-func logHandshake(ja3, ja3s, sni string) {
+func logHandshake(event handshakeEvent) {
+	handshake := event.handshake
 	if *verbose {
-		fmt.Printf("%s -> %s [%s]\n", ja3, ja3s, sni)
+		switch {
+		case handshake.SSH != nil:
+			fmt.Printf("%s -> %s [%s]\n", handshake.SSH.HASSH, handshake.SSH.HASSHServer, handshake.SSH.ServerBanner)
+		case handshake.HTTP != nil:
+			fmt.Printf("%s [%s] [%s]\n", handshake.HTTP.JA4H, handshake.HTTP.Host, handshake.HTTP.UserAgent)
+		case handshake.HTTP2 != nil:
+			fmt.Printf("%s [%s]\n", handshake.HTTP2.AkamaiHash, handshake.HTTP2.Akamai)
+		default:
+			fmt.Printf("%s (%s) -> %s (%s) [%s]\n", handshake.JA3, handshake.JA4, handshake.JA3S, handshake.JA4S, handshake.SNI)
+		}
 	}
-	eventsLock.Lock()
-	defer eventsLock.Unlock()
+	if isTLSEvent(&event) {
+		summary.add(event)
+	}
+
+	events.Lock()
+	defer events.Unlock()
 
 	// In case events are never queried, do a quick cleanup here too
-	cleanOldEvents()
+	events.expire(time.Now())
 
-	events = append(events, handshakeEvent{
-		time.Now(),
-		ja3, ja3s, sni,
-	})
+	events.add(&event)
 }
 
 func generateEventsTable(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
-	eventsLock.Lock()
-	defer eventsLock.Unlock()
-
-	cleanOldEvents()
-
-	// this is very inneficient, we should use the provided queryContext to only return events within the time range requested
-	rows := make([]map[string]string, 0, len(events))
-	for _, event := range events {
-		rows = append(rows, map[string]string{
-			"time": fmt.Sprint(event.time.Unix()),
-			"ja3":  event.ja3,
-			"ja3s": event.ja3s,
-			"sni":  event.sni,
-		})
-	}
-	return rows, nil
+	return generateRows(queryContext, handshakesTable, handshakeRow), nil
+}
+
+// generateRows returns a row for each event the table should return.
+func generateRows(queryContext table.QueryContext, from eventTable, row func(handshakeEvent) map[string]string) []map[string]string {
+	matches := readEvents(queryContext, from)
+	rows := make([]map[string]string, 0, len(matches))
+	for _, event := range matches {
+		rows = append(rows, row(*event))
+	}
+	return rows
+}
+
+// readEvents returns the stored events matching the query which are shown in a table.
+// TLS, SSH, HTTP and HTTP/2 events are kept in the same store but shown in different tables.
+func readEvents(queryContext table.QueryContext, from eventTable) []*handshakeEvent {
+	events.Lock()
+	defer events.Unlock()
+
+	events.expire(time.Now())
+
+	matches := []*handshakeEvent{}
+	for _, event := range events.query(parseEventQuery(queryContext)) {
+		if !eventTables[from](event) {
+			continue
+		}
+		if *fExpireOnRead && event.read&from != 0 {
+			// Like osquery's own evented tables, each event is only returned once
+			continue
+		}
+		matches = append(matches, event)
+	}
+
+	if *fExpireOnRead {
+		events.markRead(matches, from)
+	}
+	return matches
+}
+
+func isTLSEvent(event *handshakeEvent) bool {
+	return event.handshake.SSH == nil && event.handshake.HTTP == nil && event.handshake.HTTP2 == nil
+}
+
+// handshakeRow converts a handshake into a row with the handshakeColumns
+func handshakeRow(event handshakeEvent) map[string]string {
+	handshake := event.handshake
+	row := connectionRow(event)
+	row["ja3"] = handshake.JA3
+	row["ja3_string"] = handshake.JA3String
+	row["ja3s"] = handshake.JA3S
+	row["ja3s_string"] = handshake.JA3SString
+	row["ja4"] = handshake.JA4
+	row["ja4_r"] = handshake.JA4r
+	row["ja4s"] = handshake.JA4S
+	row["sni"] = handshake.SNI
+	row["protocol"] = handshake.Protocol
+	setDurationColumn(row, "hello_latency_us", handshake.HelloLatency)
+	setDurationColumn(row, "handshake_duration_us", handshake.HandshakeDuration)
+	return row
+}
+
+// connectionRow returns the columns common to every table of events.
+func connectionRow(event handshakeEvent) map[string]string {
+	handshake := event.handshake
+	family := syscall.AF_INET
+	if handshake.SrcIP.To4() == nil {
+		family = syscall.AF_INET6
+	}
+
+	row := map[string]string{
+		"time":      fmt.Sprint(event.time.Unix()),
+		"eid":       fmt.Sprint(event.eid),
+		"src_ip":    handshake.SrcIP.String(),
+		"src_port":  fmt.Sprint(handshake.SrcPort),
+		"dst_ip":    handshake.DstIP.String(),
+		"dst_port":  fmt.Sprint(handshake.DstPort),
+		"family":    fmt.Sprint(family),
+		"transport": handshake.Transport,
+		"interface": event.iface,
+		"ja4t":      handshake.JA4T,
+		"ja4ts":     handshake.JA4TS,
+		"ja4l":      handshake.JA4L,
+		"ja4ls":     handshake.JA4LS,
+	}
+	if handshake.JA4T != "" {
+		row["client_ttl"] = fmt.Sprint(handshake.ClientTTL)
+	}
+	if handshake.JA4TS != "" {
+		row["server_ttl"] = fmt.Sprint(handshake.ServerTTL)
+	}
+	setDurationColumn(row, "syn_to_synack_us", handshake.SYNToSYNACK)
+	setDurationColumn(row, "synack_to_ack_us", handshake.SYNACKToACK)
+	if event.uptime != 0 {
+		row["uptime"] = fmt.Sprint(event.uptime)
+	}
+	if event.process != nil {
+		row["pid"] = fmt.Sprint(event.process.PID)
+		row["process_name"] = event.process.Name
+		row["path"] = event.process.Path
+		row["uid"] = fmt.Sprint(event.process.UID)
+		row["cmdline"] = event.process.Cmdline
+	}
+	if event.container != nil {
+		row["cgroup"] = event.container.Cgroup
+		row["container_id"] = event.container.ContainerID
+		row["pod_uid"] = event.container.PodUID
+		row["net_namespace"] = fmt.Sprint(event.container.NetNamespace)
+	}
+	return row
 }
 
-func cleanOldEvents() {
-	firstRetainedEvent := 0
-	for i, event := range events {
-		if time.Now().Sub(event.time) < eventRetentionPeriod {
-			// This event is within the retention period so all the later ones will be too
-			break
+// setDurationColumn sets a column to a duration in microseconds, leaving it empty if the duration wasn't measured.
+func setDurationColumn(row map[string]string, column string, duration time.Duration) {
+	if duration != 0 {
+		row[column] = fmt.Sprint(duration.Microseconds())
+	}
+}
+
+// size estimates how much memory the event uses.
+func (e *handshakeEvent) size() int {
+	h := e.handshake
+	size := eventOverhead + len(e.iface) +
+		len(h.JA3) + len(h.JA3String) + len(h.JA4) + len(h.JA4r) + len(h.SNI) + len(h.Transport) + len(h.Protocol) +
+		len(h.JA3S) + len(h.JA3SString) + len(h.JA4S) +
+		len(h.SrcIP) + len(h.DstIP) + len(h.JA4T) + len(h.JA4TS) + len(h.JA4L) + len(h.JA4LS)
+	for _, cert := range h.Certificates {
+		size += certificateOverhead + len(cert.SHA1) + len(cert.SHA256) + len(cert.JA4X) + len(cert.Subject) + len(cert.Issuer)
+		for _, san := range cert.SANs {
+			size += len(san)
 		}
-		firstRetainedEvent = i
 	}
-	events = events[firstRetainedEvent:]
+	if h.SSH != nil {
+		size += len(h.SSH.ClientBanner) + len(h.SSH.ServerBanner) +
+			len(h.SSH.HASSH) + len(h.SSH.HASSHAlgorithms) + len(h.SSH.HASSHServer) + len(h.SSH.HASSHServerAlgorithms)
+	}
+	if h.HTTP != nil {
+		size += len(h.HTTP.Method) + len(h.HTTP.Host) + len(h.HTTP.UserAgent) + len(h.HTTP.JA4H) + len(h.HTTP.JA4Hr)
+	}
+	if h.HTTP2 != nil {
+		size += len(h.HTTP2.Settings) + len(h.HTTP2.WindowUpdate) + len(h.HTTP2.Priority) + len(h.HTTP2.PseudoHeaderOrder) +
+			len(h.HTTP2.Akamai) + len(h.HTTP2.AkamaiHash)
+	}
+	if e.process != nil {
+		size += len(e.process.Name) + len(e.process.Path) + len(e.process.Cmdline)
+	}
+	if e.container != nil {
+		size += len(e.container.Cgroup) + len(e.container.ContainerID) + len(e.container.PodUID)
+	}
+	return size
+}
+
+// storeStatsColumns are the columns of the tls_handshake_store_stats table.
+var storeStatsColumns = []table.ColumnDefinition{
+	table.BigIntColumn("events"),
+	table.BigIntColumn("bytes"),
+	table.BigIntColumn("max_events"),
+	table.BigIntColumn("max_bytes"),
+	table.BigIntColumn("retention"),
+	table.BigIntColumn("expired"),
+	table.BigIntColumn("dropped_max_events"),
+	table.BigIntColumn("dropped_max_bytes"),
+	table.BigIntColumn("summary_entries"),
+	table.BigIntColumn("summary_max_entries"),
+	table.BigIntColumn("summary_evicted"),
+}
+
+// generateStoreStatsTable reports how full the event store and fingerprint summary are and how much has
+// been dropped from them before its retention period was up, so that data loss can be alerted on.
+func generateStoreStatsTable(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	now := time.Now()
+	events.Lock()
+	events.expire(now)
+	row := map[string]string{
+		"events":             fmt.Sprint(len(events.events)),
+		"bytes":              fmt.Sprint(events.bytes),
+		"max_events":         fmt.Sprint(events.maxEvents),
+		"max_bytes":          fmt.Sprint(events.maxBytes),
+		"retention":          fmt.Sprint(int64(events.retention.Seconds())),
+		"expired":            fmt.Sprint(events.expired),
+		"dropped_max_events": fmt.Sprint(events.droppedMaxEvents),
+		"dropped_max_bytes":  fmt.Sprint(events.droppedMaxBytes),
+	}
+	events.Unlock()
+
+	summary.Lock()
+	summary.expire(now)
+	row["summary_entries"] = fmt.Sprint(len(summary.entries))
+	row["summary_max_entries"] = fmt.Sprint(summary.maxEntries)
+	row["summary_evicted"] = fmt.Sprint(summary.evicted)
+	summary.Unlock()
+
+	return []map[string]string{row}, nil
 }
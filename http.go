@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+
+	"github.com/kolide/osquery-go/plugin/table"
+)
+
+// httpColumns are the columns of the http_request_signatures table, in the order they're displayed.
+var httpColumns = eventColumns(
+	table.TextColumn("ja4h"),
+	table.TextColumn("ja4h_r"),
+	table.TextColumn("method"),
+	table.TextColumn("host"),
+	table.TextColumn("user_agent"),
+)
+
+func generateHTTPTable(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	return generateRows(queryContext, httpTable, httpRow), nil
+}
+
+func isHTTPEvent(event *handshakeEvent) bool {
+	return event.handshake.HTTP != nil
+}
+
+// httpRow converts an HTTP request into a row with the httpColumns
+func httpRow(event handshakeEvent) map[string]string {
+	request := event.handshake.HTTP
+	row := connectionRow(event)
+	row["ja4h"] = request.JA4H
+	row["ja4h_r"] = request.JA4Hr
+	row["method"] = request.Method
+	row["host"] = request.Host
+	row["user_agent"] = request.UserAgent
+	return row
+}
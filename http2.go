@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+
+	"github.com/kolide/osquery-go/plugin/table"
+)
+
+// http2Columns are the columns of the http2_connection_signatures table, in the order they're displayed.
+var http2Columns = eventColumns(
+	table.TextColumn("akamai_fingerprint"),
+	table.TextColumn("akamai_fingerprint_hash"),
+	table.TextColumn("settings"),
+	table.TextColumn("window_update"),
+	table.TextColumn("priority"),
+	table.TextColumn("pseudo_header_order"),
+)
+
+func generateHTTP2Table(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	return generateRows(queryContext, http2Table, http2Row), nil
+}
+
+func isHTTP2Event(event *handshakeEvent) bool {
+	return event.handshake.HTTP2 != nil
+}
+
+// http2Row converts an HTTP/2 fingerprint into a row with the http2Columns
+func http2Row(event handshakeEvent) map[string]string {
+	fingerprint := event.handshake.HTTP2
+	row := connectionRow(event)
+	row["akamai_fingerprint"] = fingerprint.Akamai
+	row["akamai_fingerprint_hash"] = fingerprint.AkamaiHash
+	row["settings"] = fingerprint.Settings
+	row["window_update"] = fingerprint.WindowUpdate
+	row["priority"] = fingerprint.Priority
+	row["pseudo_header_order"] = fingerprint.PseudoHeaderOrder
+	return row
+}
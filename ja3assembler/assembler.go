@@ -0,0 +1,91 @@
+package ja3assembler
+
+import (
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+// endOfTime is later than any packet could have been captured.
+var endOfTime = time.Unix(1<<62, 0)
+
+// Assembler reassembles the TLS handshakes carried over TCP streams, QUIC connections and DTLS,
+// and the start of SSH connections.
+type Assembler struct {
+	// Verbose logs each connection which is given up on because the other direction was never seen
+	Verbose bool
+
+	streams *assembler
+	tcp     *tcpassembly.Assembler
+	quic    *quicAssembler
+	dtls    *dtlsAssembler
+}
+
+// NewAssembler creates an Assembler which calls callback with every handshake it reassembles.
+func NewAssembler(callback func(Handshake)) *Assembler {
+	streams := &assembler{
+		callback:         callback,
+		unmatchedStreams: map[key]*bidirectionalStream{},
+		connecting:       map[key]*bidirectionalStream{},
+	}
+	return &Assembler{
+		streams: streams,
+		tcp:     tcpassembly.NewAssembler(tcpassembly.NewStreamPool(streams)),
+		quic:    newQUICAssembler(callback),
+		dtls:    newDTLSAssembler(callback),
+	}
+}
+
+// Assemble handles a captured packet. The packet's capture timestamp is used as the time it was seen
+// so that handshakes read from files are recorded at the time they happened.
+func (a *Assembler) Assemble(packet gopacket.Packet) {
+	network := packet.NetworkLayer()
+	if network == nil {
+		return
+	}
+	timestamp := packet.Metadata().Timestamp
+
+	switch transport := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		a.streams.current = &capturedSegment{transport, hopLimit(network), timestamp}
+		a.streams.handleACK(network.NetworkFlow(), a.streams.current)
+		a.tcp.AssembleWithTimestamp(network.NetworkFlow(), transport, timestamp)
+		a.streams.current = nil
+	case *layers.UDP:
+		// DTLS records and QUIC long header packets are easily told apart by their first byte
+		if !a.dtls.assemble(network.NetworkFlow(), transport.TransportFlow(), transport.Payload, timestamp) {
+			a.quic.assemble(network.NetworkFlow(), transport.TransportFlow(), transport.Payload, timestamp)
+		}
+	}
+}
+
+// hopLimit returns the TTL of an IPv4 packet or the hop limit of an IPv6 packet.
+func hopLimit(network gopacket.NetworkLayer) uint8 {
+	switch ip := network.(type) {
+	case *layers.IPv4:
+		return ip.TTL
+	case *layers.IPv6:
+		return ip.HopLimit
+	}
+	return 0
+}
+
+// FlushOlderThan finishes off every connection which hasn't had a packet since the cutoff,
+// e.g. because it stalled part way through the handshake. It must not be called at the same time as Assemble.
+func (a *Assembler) FlushOlderThan(cutoff time.Time) {
+	a.tcp.FlushOlderThan(cutoff)
+	a.streams.collectOldStreams(cutoff, a.Verbose)
+	a.quic.expire(cutoff)
+	a.dtls.expire(cutoff)
+}
+
+// FlushAll finishes off every connection still in progress, e.g. because there are no more packets to read.
+func (a *Assembler) FlushAll() {
+	a.tcp.FlushAll()
+	// Connections which were only seen in one direction are still waiting for the other
+	a.streams.collectOldStreams(endOfTime, a.Verbose)
+	a.quic.flushAll()
+	a.dtls.flushAll()
+}
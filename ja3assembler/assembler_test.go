@@ -0,0 +1,157 @@
+package ja3assembler
+
+import (
+	"bytes"
+	"crypto/tls"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// readCapture returns every handshake reassembled from a capture file in testdata.
+func readCapture(t *testing.T, file string) []Handshake {
+	f, err := os.Open(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var packets []gopacket.Packet
+	for packet := range gopacket.NewPacketSource(r, r.LinkType()).Packets() {
+		packets = append(packets, packet)
+	}
+	return assemble(packets)
+}
+
+// The captures are of curl 7.88.1 (OpenSSL 3.0.17) and openssl s_client talking to openssl s_server, recorded
+// by testdata/generate.go. The JA3 and JA3S values were checked against an independent implementation.
+func TestCaptures(t *testing.T) {
+	tests := []struct {
+		file                string
+		ja3, ja3String, ja4 string
+		ja3s, ja3sString    string
+		ja4s, ja4x          string
+	}{
+		{
+			file:       "curl-tls12.pcap",
+			ja3:        "87b9bfc7da97115ed2276737b09f8d74",
+			ja3String:  "771,49196-49200-159-52393-52392-52394-49195-49199-158-49188-49192-107-49187-49191-103-49162-49172-57-49161-49171-51-157-156-61-60-53-47-255,0-11-10-16-22-23-13,29-23-30-25-24,0-1-2",
+			ja4:        "t12d2807h2_d943125447b4_a44c6288192a",
+			ja3s:       "a548c224ffe85e7076d7897c27f6758c",
+			ja3sString: "771,49196,65281-11-23",
+			ja4s:       "t120300_c02c_460f64128655",
+			ja4x:       "711618dec96d_711618dec96d_ecad00239dd7",
+		},
+		{
+			file:       "curl-tls13.pcap",
+			ja3:        "89dfc51e72bdcb94e4ba4622588002b1",
+			ja3String:  "771,4866-4867-4865-255,0-11-10-16-22-23-49-13-43-45-51-21,29-23-30-25-24-256-257-258-259-260,0-1-2",
+			ja4:        "t13d0412h2_16476d049b0b_85302d7e289e",
+			ja3s:       "15af977ce25de452b96affa2addb1036",
+			ja3sString: "771,4866,43-51",
+			ja4s:       "t130200_1302_a56c5b993250",
+			// The certificate is encrypted in TLS 1.3
+		},
+		{
+			file:       "s_client-tls12.pcap",
+			ja3:        "871a754af286dfb70c1b53c6887c62e0",
+			ja3String:  "771,49196-49200-159-52393-52392-52394-49195-49199-158-49188-49192-107-49187-49191-103-49162-49172-57-49161-49171-51-157-156-61-60-53-47-255,0-11-10-35-22-23-13,29-23-30-25-24,0-1-2",
+			ja4:        "t12d280700_d943125447b4_e7e480e5a997",
+			ja3s:       "abade5a4a7f42baf54766e5d108283b6",
+			ja3sString: "771,49196,65281-11-35-23",
+			ja4s:       "t120400_c02c_12a20535f9be",
+			ja4x:       "711618dec96d_711618dec96d_ecad00239dd7",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			handshakes := readCapture(t, test.file)
+			if len(handshakes) != 1 {
+				t.Fatalf("got %d handshakes, want 1", len(handshakes))
+			}
+			h := handshakes[0]
+			if h.SrcIP.String() != "10.0.0.1" || h.SrcPort != 50000 || h.DstIP.String() != "10.0.0.2" || h.DstPort != 443 {
+				t.Errorf("got connection %v:%d -> %v:%d, want the client first", h.SrcIP, h.SrcPort, h.DstIP, h.DstPort)
+			}
+			if h.SNI != "example.com" || h.Transport != TransportTCP {
+				t.Errorf("got SNI %q over %q", h.SNI, h.Transport)
+			}
+			if h.JA3 != test.ja3 || h.JA3String != test.ja3String {
+				t.Errorf("got JA3 %s (%s), want %s (%s)", h.JA3, h.JA3String, test.ja3, test.ja3String)
+			}
+			if h.JA4 != test.ja4 {
+				t.Errorf("got JA4 %s, want %s", h.JA4, test.ja4)
+			}
+			if h.JA3S != test.ja3s || h.JA3SString != test.ja3sString {
+				t.Errorf("got JA3S %s (%s), want %s (%s)", h.JA3S, h.JA3SString, test.ja3s, test.ja3sString)
+			}
+			if h.JA4S != test.ja4s {
+				t.Errorf("got JA4S %s, want %s", h.JA4S, test.ja4s)
+			}
+
+			var ja4x string
+			if len(h.Certificates) > 0 {
+				ja4x = h.Certificates[0].JA4X
+			}
+			if ja4x != test.ja4x {
+				t.Errorf("got JA4X %q, want %q", ja4x, test.ja4x)
+			}
+		})
+	}
+}
+
+func TestFlushOlderThan(t *testing.T) {
+	// A server which never answers the ClientHello
+	packets := tcpConnection(testTLSHandshake(t, tls.VersionTLS13)[:1])
+
+	var handshakes []Handshake
+	assembler := NewAssembler(func(handshake Handshake) {
+		handshakes = append(handshakes, handshake)
+	})
+	for _, p := range packets {
+		assembler.Assemble(p)
+	}
+	assembler.FlushOlderThan(testStart)
+	if len(handshakes) != 0 {
+		t.Fatalf("connection with recent packets was flushed: %+v", handshakes)
+	}
+	assembler.FlushOlderThan(testStart.Add(time.Minute))
+	if len(handshakes) != 1 || handshakes[0].JA3 == "" || handshakes[0].JA3S != "" {
+		t.Fatalf("got %+v, want just the ClientHello", handshakes)
+	}
+}
+
+// Only the client's side of the connection was captured, e.g. because of asymmetric routing.
+func TestFlushAllOneSided(t *testing.T) {
+	var clientPackets []gopacket.Packet
+	for _, p := range tcpConnection(testTLSHandshake(t, tls.VersionTLS13)) {
+		if p.NetworkLayer().NetworkFlow().Src().String() == "10.0.0.1" {
+			clientPackets = append(clientPackets, p)
+		}
+	}
+
+	// Timing out the missing direction is only logged in verbose mode
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	handshakes := assemble(clientPackets)
+	if logged.Len() != 0 {
+		t.Errorf("logged %q when not verbose", logged.String())
+	}
+	if len(handshakes) != 1 || handshakes[0].JA3 == "" || handshakes[0].JA3S != "" {
+		t.Fatalf("got %+v, want just the ClientHello", handshakes)
+	}
+	if h := handshakes[0]; h.SrcPort != 50000 || h.DstPort != 443 {
+		t.Errorf("handshake from port %d to %d, want from the client", h.SrcPort, h.DstPort)
+	}
+}
@@ -0,0 +1,97 @@
+package ja3assembler
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// Certificate summarises a certificate sent by the server.
+// Only the fingerprints are set if the certificate couldn't be parsed.
+type Certificate struct {
+	SHA1   string
+	SHA256 string
+	JA4X   string
+
+	Subject    string
+	Issuer     string
+	SANs       []string
+	NotBefore  time.Time
+	NotAfter   time.Time
+	KeyType    string
+	KeyBits    int
+	SelfSigned bool
+}
+
+// parseCertificateMessage returns the certificate chain from a TLS 1.2 (or earlier) Certificate message.
+func parseCertificateMessage(data []byte) []Certificate {
+	s := cryptobyte.String(data)
+	var certificates cryptobyte.String
+	if !s.Skip(4) || // message type and uint24 length field
+		!s.ReadUint24LengthPrefixed(&certificates) {
+		return nil
+	}
+
+	chain := []Certificate{}
+	for !certificates.Empty() {
+		var der cryptobyte.String
+		if !certificates.ReadUint24LengthPrefixed(&der) {
+			break
+		}
+		chain = append(chain, parseCertificate(der))
+	}
+	return chain
+}
+
+func parseCertificate(der []byte) Certificate {
+	sha1Sum, sha256Sum := sha1.Sum(der), sha256.Sum256(der)
+	c := Certificate{
+		SHA1:   hex.EncodeToString(sha1Sum[:]),
+		SHA256: hex.EncodeToString(sha256Sum[:]),
+		JA4X:   calculateJA4X(der),
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return c
+	}
+
+	c.Subject = cert.Subject.String()
+	c.Issuer = cert.Issuer.String()
+	c.NotBefore, c.NotAfter = cert.NotBefore, cert.NotAfter
+	c.SANs = append(c.SANs, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		c.SANs = append(c.SANs, ip.String())
+	}
+	c.SANs = append(c.SANs, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		c.SANs = append(c.SANs, uri.String())
+	}
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		c.KeyType, c.KeyBits = "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		c.KeyType, c.KeyBits = "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		c.KeyType, c.KeyBits = "Ed25519", 256
+	case *dsa.PublicKey:
+		c.KeyType, c.KeyBits = "DSA", key.P.BitLen()
+	default:
+		c.KeyType = cert.PublicKeyAlgorithm.String()
+	}
+
+	// Self-signed certificates are their own issuer and are signed by their own key
+	c.SelfSigned = bytes.Equal(cert.RawSubject, cert.RawIssuer) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+	return c
+}
@@ -0,0 +1,183 @@
+package ja3assembler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	oidSHA256WithRSA    = []byte{0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x01, 0x0b}
+	oidRSAEncryption    = []byte{0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x01, 0x01}
+	oidCountry          = []byte{0x55, 0x04, 0x06}
+	oidCommonName       = []byte{0x55, 0x04, 0x03}
+	oidOrganization     = []byte{0x55, 0x04, 0x0a}
+	oidBasicConstraints = []byte{0x55, 0x1d, 0x13}
+	oidKeyUsage         = []byte{0x55, 0x1d, 0x0f}
+)
+
+// rawCertificate returns a DER encoded certificate with the given issuer and subject attribute types and extensions,
+// and an explicit version 3 field if version is set. Its RSA key isn't valid so crypto/x509 can't parse it.
+func rawCertificate(version bool, issuer, subject [][]byte, extensions [][]byte) []byte {
+	name := func(b *cryptobyte.Builder, attributes [][]byte) {
+		b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			for _, oid := range attributes {
+				b.AddASN1(cryptobyte_asn1.SET, func(b *cryptobyte.Builder) {
+					b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+						b.AddASN1(cryptobyte_asn1.OBJECT_IDENTIFIER, func(b *cryptobyte.Builder) { b.AddBytes(oid) })
+						b.AddASN1(cryptobyte_asn1.UTF8String, func(b *cryptobyte.Builder) { b.AddBytes([]byte("test")) })
+					})
+				})
+			}
+		})
+	}
+	algorithm := func(b *cryptobyte.Builder, oid []byte) {
+		b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1(cryptobyte_asn1.OBJECT_IDENTIFIER, func(b *cryptobyte.Builder) { b.AddBytes(oid) })
+			b.AddASN1NULL()
+		})
+	}
+
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			if version {
+				b.AddASN1(cryptobyte_asn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+					b.AddASN1Int64(2)
+				})
+			}
+			b.AddASN1Int64(1)
+			algorithm(b, oidSHA256WithRSA)
+			name(b, issuer)
+			b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				for _, t := range []time.Time{testStart, testStart.AddDate(1, 0, 0)} {
+					b.AddASN1(cryptobyte_asn1.UTCTime, func(b *cryptobyte.Builder) { b.AddBytes([]byte(t.Format("060102150405Z0700"))) })
+				}
+			})
+			name(b, subject)
+			b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				algorithm(b, oidRSAEncryption)
+				b.AddASN1BitString([]byte{1, 2, 3})
+			})
+			if extensions != nil {
+				b.AddASN1(cryptobyte_asn1.Tag(3).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+					b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+						for _, oid := range extensions {
+							b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+								b.AddASN1(cryptobyte_asn1.OBJECT_IDENTIFIER, func(b *cryptobyte.Builder) { b.AddBytes(oid) })
+								b.AddASN1OctetString(nil)
+							})
+						}
+					})
+				})
+			}
+		})
+		algorithm(b, oidSHA256WithRSA)
+		b.AddASN1BitString([]byte{0})
+	})
+	return b.BytesOrPanic()
+}
+
+// The expected fingerprints were calculated from the OIDs by an independent implementation.
+func TestJA4X(t *testing.T) {
+	tests := []struct {
+		name string
+		der  []byte
+		ja4x string
+	}{
+		{
+			name: "crypto/x509",
+			der:  testCertificate(t).Certificate[0],
+			ja4x: "769119f9990f_769119f9990f_a65cdb821cd6", // O,CN O,CN keyUsage,subjectAltName
+		},
+		{
+			name: "unparseable key",
+			der:  rawCertificate(true, [][]byte{oidCountry, oidCommonName}, [][]byte{oidOrganization}, [][]byte{oidBasicConstraints, oidKeyUsage}),
+			ja4x: "4ce939b68fae_b757977db3a9_f3465fcaa762", // C,CN O basicConstraints,keyUsage
+		},
+		{
+			name: "version 1",
+			der:  rawCertificate(false, [][]byte{oidCommonName}, [][]byte{oidCommonName}, nil),
+			ja4x: "7022c563de38_7022c563de38_000000000000",
+		},
+		{
+			name: "truncated",
+			der:  testCertificate(t).Certificate[0][:100],
+			ja4x: "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := calculateJA4X(test.der); got != test.ja4x {
+				t.Errorf("calculateJA4X() = %q, want %q", got, test.ja4x)
+			}
+		})
+	}
+}
+
+func TestParseCertificateUnparseable(t *testing.T) {
+	der := rawCertificate(true, [][]byte{oidCommonName}, [][]byte{oidCommonName}, [][]byte{oidKeyUsage})
+	if _, err := x509.ParseCertificate(der); err == nil {
+		t.Fatal("crypto/x509 parsed the certificate so this doesn't test anything")
+	}
+
+	c := parseCertificate(der)
+	if c.SHA256 == "" || c.JA4X == "" {
+		t.Errorf("fingerprints of an unparseable certificate weren't set: %+v", c)
+	}
+	if c.Subject != "" {
+		t.Errorf("unparseable certificate has subject %q", c.Subject)
+	}
+}
+
+func TestParseCertificate(t *testing.T) {
+	certificate := testCertificate(t)
+	der := certificate.Certificate[0]
+	sha1Sum, sha256Sum := sha1.Sum(der), sha256.Sum256(der)
+	want := Certificate{
+		SHA1:       hex.EncodeToString(sha1Sum[:]),
+		SHA256:     hex.EncodeToString(sha256Sum[:]),
+		JA4X:       "769119f9990f_769119f9990f_a65cdb821cd6",
+		Subject:    "CN=example.com,O=osquery-ja3 test",
+		Issuer:     "CN=example.com,O=osquery-ja3 test",
+		SANs:       []string{"example.com"},
+		NotBefore:  testStart,
+		NotAfter:   testStart.AddDate(10, 0, 0),
+		KeyType:    "ECDSA",
+		KeyBits:    256,
+		SelfSigned: true,
+	}
+	got := parseCertificate(der)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCertificate() = %+v, want %+v", got, want)
+	}
+
+	// The same subject and issuer, but signed by a different key
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := *parsed
+	issuer.PublicKey = &otherKey.PublicKey
+	der, err = x509.CreateCertificate(rand.Reader, parsed, &issuer, parsed.PublicKey, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parseCertificate(der); got.SelfSigned || got.Subject != got.Issuer {
+		t.Errorf("certificate signed by another key has SelfSigned %v, subject %q and issuer %q", got.SelfSigned, got.Subject, got.Issuer)
+	}
+}
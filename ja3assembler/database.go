@@ -0,0 +1,126 @@
+package ja3assembler
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var (
+	// postgresSSLRequest is the whole of the message a Postgres client sends to ask to upgrade to TLS.
+	// The server replies with a single 'S' if it agrees or 'N' if not.
+	postgresSSLRequest = []byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}
+	// postgresGSSENCRequest is the equivalent request for GSSAPI encryption, which clients may try before TLS.
+	postgresGSSENCRequest = []byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x30}
+)
+
+// MySQL capability flags
+const (
+	mysqlClientProtocol41 = 0x0200
+	mysqlClientSSL        = 0x0800
+)
+
+var (
+	postgres = &plaintextProtocol{"postgres", false, nextPostgresMessage, func(unit []byte, client bool) bool {
+		if client {
+			return bytes.Equal(unit, postgresSSLRequest)
+		}
+		return string(unit) == "S"
+	}}
+	mysql = &plaintextProtocol{"mysql", false, nextMySQLPacket, isMySQLSSLRequest}
+)
+
+// detectDatabaseProtocol recognises the start of a database protocol which negotiates TLS inside the protocol itself.
+// It returns which protocol this is and whether the data was sent by the client.
+func detectDatabaseProtocol(data []byte) (protocol *plaintextProtocol, client bool) {
+	switch {
+	case bytes.HasPrefix(data, postgresSSLRequest), bytes.HasPrefix(data, postgresGSSENCRequest):
+		return postgres, true
+	case isPostgresSSLResponse(data), len(data) > 0 && data[0] == 'N' && isPostgresSSLResponse(data[1:]):
+		// The server's answer to an SSLRequest, possibly after refusing a GSSENCRequest
+		return postgres, false
+	}
+
+	payload, sequence, ok := readMySQLPacket(data)
+	switch {
+	case !ok:
+		return nil, false
+	case sequence == 0 && len(payload) > 1 && payload[0] == 10 && '0' <= payload[1] && payload[1] <= '9':
+		// The server speaks first with a protocol version 10 greeting, followed by its version string
+		return mysql, false
+	case sequence == 1 && len(payload) >= 32 && binary.LittleEndian.Uint32(payload)&mysqlClientProtocol41 != 0:
+		// The client's SSLRequest or HandshakeResponse
+		return mysql, true
+	}
+	return nil, false
+}
+
+// isPostgresSSLResponse returns whether data is a Postgres server agreeing to an SSLRequest, with or without the ServerHello.
+func isPostgresSSLResponse(data []byte) bool {
+	return len(data) > 0 && data[0] == 'S' && (len(data) == 1 || data[1] == recordTypeHandshake)
+}
+
+// nextPostgresMessage returns the length of the first Postgres message.
+func nextPostgresMessage(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	headerLength := 0
+	switch data[0] {
+	case 'S', 'N', 'G':
+		// The single byte answer to an SSLRequest or GSSENCRequest
+		return 1
+	case 0:
+		// Messages sent by the client before it has started up are untyped
+	default:
+		// Every other message starts with its type
+		headerLength = 1
+	}
+	if len(data) < headerLength+4 {
+		return 0
+	}
+	// The length includes itself but not the type
+	length := headerLength + int(binary.BigEndian.Uint32(data[headerLength:]))
+	if length < headerLength+4 || len(data) < length {
+		return 0
+	}
+	return length
+}
+
+// readMySQLPacket parses the first MySQL packet, returning its payload and sequence number.
+func readMySQLPacket(data []byte) (payload []byte, sequence byte, ok bool) {
+	if len(data) < 4 {
+		return nil, 0, false
+	}
+	length := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+	if len(data) < 4+length {
+		return nil, 0, false
+	}
+	return data[4 : 4+length], data[3], true
+}
+
+// nextMySQLPacket returns the length of the first MySQL packet.
+func nextMySQLPacket(data []byte) int {
+	payload, _, ok := readMySQLPacket(data)
+	if !ok {
+		return 0
+	}
+	return 4 + len(payload)
+}
+
+// isMySQLSSLRequest returns whether a MySQL packet is a client's SSLRequest, or a server's greeting saying it supports TLS.
+// The server doesn't reply to the SSLRequest so the client's ClientHello comes straight after it.
+func isMySQLSSLRequest(packet []byte, client bool) bool {
+	payload, _, _ := readMySQLPacket(packet)
+	if client {
+		// An SSLRequest is a truncated HandshakeResponse: just the capabilities, max packet size, character set and padding
+		return len(payload) == 32 && binary.LittleEndian.Uint32(payload)&mysqlClientSSL != 0
+	}
+
+	// Greeting: protocol version, NUL terminated server version, connection ID, 8 bytes of auth data, filler then capabilities
+	versionEnd := bytes.IndexByte(payload, 0)
+	capabilitiesStart := versionEnd + 1 + 4 + 8 + 1
+	if versionEnd < 0 || len(payload) < capabilitiesStart+2 {
+		return false
+	}
+	return binary.LittleEndian.Uint16(payload[capabilitiesStart:])&mysqlClientSSL != 0
+}
@@ -0,0 +1,79 @@
+package ja3assembler
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+// mysqlGreeting returns a MySQL server's initial handshake packet, which says whether the server supports TLS.
+func mysqlGreeting(supportsTLS bool) []byte {
+	capabilities := byte(0xff &^ (mysqlClientSSL >> 8))
+	if supportsTLS {
+		capabilities = 0xff
+	}
+	payload := append([]byte{10}, "8.0.32\x00"...)
+	payload = append(payload, 1, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8, 0, 0xff, capabilities, 0x21, 2, 0, 0xff, 0xc1, 21)
+	payload = append(payload, make([]byte, 10)...)
+	payload = append(payload, "abcdefghijkl\x00mysql_native_password\x00"...)
+	return append([]byte{byte(len(payload)), 0, 0, 0}, payload...)
+}
+
+// mysqlSSLRequest is a client's request to upgrade to TLS: capabilities, max packet size, character set and padding.
+var mysqlSSLRequest = append([]byte{32, 0, 0, 1, 0x05, 0xaa, 0xbf, 0x41, 0, 0, 0, 1, 0xff}, make([]byte, 23)...)
+
+func TestDatabases(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocol  string
+		plaintext []segment
+	}{
+		{"postgres", "postgres", []segment{
+			{true, postgresSSLRequest},
+			{false, []byte("S")},
+		}},
+		{"postgres after GSSAPI refused", "postgres", []segment{
+			{true, postgresGSSENCRequest},
+			{false, []byte("N")},
+			{true, postgresSSLRequest},
+			{false, []byte("S")},
+		}},
+		{"mysql", "mysql", []segment{
+			{false, mysqlGreeting(true)},
+			{true, mysqlSSLRequest},
+		}},
+	}
+	for _, test := range tests {
+		for name, version := range map[string]uint16{"TLS 1.2": tls.VersionTLS12, "TLS 1.3": tls.VersionTLS13} {
+			t.Run(test.name+" "+name, func(t *testing.T) {
+				segments := append(append([]segment{}, test.plaintext...), testTLSHandshake(t, version)...)
+				handshakes := assemble(tcpConnection(segments))
+				if len(handshakes) != 1 {
+					t.Fatalf("got %d handshakes, want 1", len(handshakes))
+				}
+				h := handshakes[0]
+				if h.Protocol != test.protocol || h.JA3 == "" || h.JA3S == "" {
+					t.Errorf("got protocol %q, JA3 %q and JA3S %q", h.Protocol, h.JA3, h.JA3S)
+				}
+			})
+		}
+	}
+}
+
+func TestDatabaseTLSRefused(t *testing.T) {
+	tests := map[string][]segment{
+		"postgres": {
+			{true, postgresSSLRequest},
+			{false, []byte("N")},
+			{true, []byte{0, 0, 0, 9, 0, 3, 0, 0, 0}},
+		},
+		"mysql without TLS": {
+			{false, mysqlGreeting(false)},
+			{true, append([]byte{36, 0, 0, 1, 0x05, 0xa2, 0xbf, 0x41, 0, 0, 0, 1, 0xff}, make([]byte, 27)...)},
+		},
+	}
+	for name, segments := range tests {
+		if handshakes := assemble(tcpConnection(segments)); len(handshakes) != 0 {
+			t.Errorf("%s: got %+v from a connection which never upgraded", name, handshakes)
+		}
+	}
+}
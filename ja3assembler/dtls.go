@@ -0,0 +1,286 @@
+package ja3assembler
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/google/gopacket"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const (
+	dtlsRecordHeaderLength    = 13
+	dtlsHandshakeHeaderLength = 12
+
+	typeHelloVerifyRequest byte = 0x03
+
+	dtlsVersion13 = 0xfefc
+
+	// dtlsTimeout is how long to wait for the rest of a DTLS handshake after the last packet
+	// before giving up and reporting whatever has been seen so far.
+	dtlsTimeout = 30 * time.Second
+	// maxDTLSConnections limits how many DTLS connections are tracked at once
+	maxDTLSConnections = 10000
+	// maxDTLSMessageLength limits the length of the handshake messages which are reassembled
+	maxDTLSMessageLength = 64 << 10
+	// maxDTLSPendingMessages limits how far ahead of the next expected message fragments are buffered
+	maxDTLSPendingMessages = 8
+)
+
+// helloRetryRequestRandom is the random value of a ServerHello which is actually a HelloRetryRequest (RFC 8446, Section 4.1.3)
+var helloRetryRequestRandom = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+// dtlsAssembler recovers the handshakes of DTLS connections. Unlike TLS, DTLS handshake messages can be
+// fragmented across records and datagrams which may arrive out of order or be retransmitted.
+type dtlsAssembler struct {
+	udpConnections
+}
+
+// dtlsConnection tracks the handshake of a single DTLS connection.
+type dtlsConnection struct {
+	udpHandshake
+	clientMessages, serverMessages dtlsHandshakeStream
+	serverDone                     bool // if true, nothing more of interest will be sent by the server
+}
+
+// dtlsHandshakeStream reassembles the handshake messages sent in one direction and puts them back in order.
+type dtlsHandshakeStream struct {
+	started  bool
+	nextSeq  uint16
+	messages map[uint16]*dtlsMessage
+}
+
+// dtlsMessage is a (possibly partially received) handshake message.
+type dtlsMessage struct {
+	messageType byte
+	body        []byte
+	received    []bool
+	remaining   int // how many bytes of the body haven't been received yet
+}
+
+func newDTLSAssembler(callback func(Handshake)) *dtlsAssembler {
+	return &dtlsAssembler{newUDPConnections(callback, dtlsTimeout, maxDTLSConnections)}
+}
+
+// assemble handles a UDP datagram, returning false if it isn't part of a DTLS handshake.
+func (a *dtlsAssembler) assemble(netFlow, udpFlow gopacket.Flow, datagram []byte, timestamp time.Time) bool {
+	k := key{netFlow, udpFlow}
+	conn, _ := a.connections[k].(*dtlsConnection)
+	if conn == nil {
+		// Only a client can start a connection, and the first thing it sends is a ClientHello
+		if !startsDTLSHandshake(datagram) {
+			return false
+		}
+		if a.full() {
+			return true
+		}
+		conn = &dtlsConnection{udpHandshake: udpHandshake{client: k}}
+		a.track(netFlow, udpFlow, conn)
+	}
+
+	if !conn.done {
+		conn.lastSeen = timestamp
+		conn.addDatagram(datagram, k == conn.client, timestamp)
+		if conn.clientHello.JA3 != "" && conn.serverDone {
+			a.finish(conn)
+		}
+	}
+
+	a.expireTimedOut(timestamp)
+	return true
+}
+
+// startsDTLSHandshake returns whether a datagram starts with a DTLS record containing (the start of) a ClientHello.
+func startsDTLSHandshake(datagram []byte) bool {
+	contentType, version, epoch, fragment, _, ok := readDTLSRecord(datagram)
+	return ok && contentType == recordTypeHandshake && isDTLSVersion(version) && epoch == 0 &&
+		len(fragment) >= dtlsHandshakeHeaderLength && fragment[0] == typeClientHello
+}
+
+// readDTLSRecord parses the first plaintext DTLS record in data.
+func readDTLSRecord(data []byte) (contentType byte, version, epoch uint16, fragment, rest []byte, ok bool) {
+	s := cryptobyte.String(data)
+	var body cryptobyte.String
+	if !s.ReadUint8(&contentType) || !s.ReadUint16(&version) || !s.ReadUint16(&epoch) ||
+		!s.Skip(6) || // sequence number
+		!s.ReadUint16LengthPrefixed(&body) {
+		return 0, 0, 0, nil, nil, false
+	}
+	return contentType, version, epoch, body, s, true
+}
+
+func isDTLSVersion(version uint16) bool {
+	return version >= 0xfe00
+}
+
+// addDatagram parses the records in a datagram and any handshake messages that are now complete.
+func (c *dtlsConnection) addDatagram(datagram []byte, fromClient bool, timestamp time.Time) {
+	stream := &c.serverMessages
+	if fromClient {
+		stream = &c.clientMessages
+	}
+
+	for len(datagram) > 0 {
+		contentType, version, epoch, fragment, rest, ok := readDTLSRecord(datagram)
+		if !ok || contentType != recordTypeHandshake || !isDTLSVersion(version) || epoch != 0 {
+			// e.g. a ChangeCipherSpec or encrypted record: the unencrypted part of the handshake is over
+			if !fromClient && c.serverHello.JA3S != "" {
+				c.serverDone = true
+			}
+			return
+		}
+		datagram = rest
+
+		for len(fragment) >= dtlsHandshakeHeaderLength {
+			messageType := fragment[0]
+			length := int(fragment[1])<<16 | int(fragment[2])<<8 | int(fragment[3])
+			seq := uint16(fragment[4])<<8 | uint16(fragment[5])
+			offset := int(fragment[6])<<16 | int(fragment[7])<<8 | int(fragment[8])
+			fragmentLength := int(fragment[9])<<16 | int(fragment[10])<<8 | int(fragment[11])
+			if len(fragment) < dtlsHandshakeHeaderLength+fragmentLength {
+				break
+			}
+			stream.add(messageType, length, seq, offset, fragment[dtlsHandshakeHeaderLength:dtlsHandshakeHeaderLength+fragmentLength])
+			fragment = fragment[dtlsHandshakeHeaderLength+fragmentLength:]
+		}
+
+		for {
+			messageType, body, ok := stream.next()
+			if !ok {
+				break
+			}
+			c.handleMessage(messageType, body, fromClient, timestamp)
+		}
+	}
+}
+
+// handleMessage parses a complete handshake message.
+func (c *dtlsConnection) handleMessage(messageType byte, body []byte, fromClient bool, timestamp time.Time) {
+	switch {
+	case fromClient && messageType == typeClientHello:
+		// After a HelloVerifyRequest the client sends its ClientHello again with the cookie the server gave it.
+		// Only the cookie should be different but the later ClientHello takes precedence.
+		msg := &clientHelloMsg{}
+		if !msg.unmarshal(tlsMessage(messageType, withoutDTLSCookie(body))) {
+			return
+		}
+		c.clientHello.SNI = msg.serverName
+		c.clientHello.JA3, c.clientHello.JA3String = calculateJA3(msg)
+		c.clientHello.JA4, c.clientHello.JA4r = calculateJA4(msg, TransportDTLS)
+		c.clientHello.setEndpoints(c.client.net, c.client.transport)
+		c.clientHello.Transport = TransportDTLS
+		if c.clientHello.Time.IsZero() {
+			c.clientHello.Time = timestamp
+		}
+
+	case fromClient:
+		// Nothing else the client sends is interesting
+
+	case messageType == typeHelloVerifyRequest:
+		// The server wants the client to prove it can receive at its address so the ClientHello will be sent again
+
+	case messageType == typeServerHello && c.serverHello.JA3S == "":
+		msg := &serverHelloMsg{}
+		if !msg.unmarshal(tlsMessage(messageType, body)) || bytes.Equal(msg.random, helloRetryRequestRandom) {
+			// A HelloRetryRequest is followed by another ClientHello and then the real ServerHello
+			return
+		}
+		c.serverHello.JA3S, c.serverHello.JA3SString = calculateJA3S(msg)
+		c.serverHello.JA4S = calculateJA4S(msg, TransportDTLS)
+		c.serverHello.setEndpoints(c.client.net, c.client.transport)
+		c.serverHello.Transport = TransportDTLS
+		c.serverHello.Time = timestamp
+		// Everything after the ServerHello is encrypted in DTLS 1.3, otherwise the server's certificate should follow
+		c.serverDone = msg.supportedVersion == dtlsVersion13
+
+	case messageType == typeCertificate && c.serverHello.JA3S != "":
+		c.serverHello.Certificates = parseCertificateMessage(tlsMessage(messageType, body))
+		c.serverDone = true
+
+	case c.serverHello.JA3S != "":
+		// The server didn't send a certificate, e.g. because this is a resumed session
+		c.serverDone = true
+	}
+}
+
+// tlsMessage adds a TLS handshake message header to a message body so that it can be parsed like a TLS message.
+func tlsMessage(messageType byte, body []byte) []byte {
+	message := make([]byte, 0, handshakeHeaderLength+len(body))
+	message = append(message, messageType, byte(len(body)>>16), byte(len(body)>>8), byte(len(body)))
+	return append(message, body...)
+}
+
+// withoutDTLSCookie removes the cookie from the body of a DTLS ClientHello, which otherwise has the same format as in TLS.
+func withoutDTLSCookie(body []byte) []byte {
+	// client_version, random, session_id, cookie, ...
+	const sessionIDStart = 2 + 32
+	if len(body) < sessionIDStart+1 {
+		return body
+	}
+	sessionIDEnd := sessionIDStart + 1 + int(body[sessionIDStart])
+	if len(body) < sessionIDEnd+1 {
+		return body
+	}
+	cookieEnd := sessionIDEnd + 1 + int(body[sessionIDEnd])
+	if len(body) < cookieEnd {
+		return body
+	}
+	return append(append([]byte{}, body[:sessionIDEnd]...), body[cookieEnd:]...)
+}
+
+func (c *dtlsConnection) discard() {
+	c.clientMessages, c.serverMessages = dtlsHandshakeStream{}, dtlsHandshakeStream{}
+}
+
+// add adds a fragment of a handshake message to the stream.
+func (s *dtlsHandshakeStream) add(messageType byte, length int, seq uint16, offset int, fragment []byte) {
+	if !s.started {
+		if messageType != typeClientHello && messageType != typeServerHello && messageType != typeHelloVerifyRequest {
+			// The start of the handshake was missed
+			return
+		}
+		s.started, s.nextSeq = true, seq
+		s.messages = map[uint16]*dtlsMessage{}
+	}
+	// seq-s.nextSeq can't wrap around once seq is known not to be before nextSeq
+	if seq < s.nextSeq || seq-s.nextSeq >= maxDTLSPendingMessages || length > maxDTLSMessageLength || offset+len(fragment) > length {
+		// Either a retransmission of a message already handled or something invalid
+		return
+	}
+
+	message := s.messages[seq]
+	if message == nil {
+		message = &dtlsMessage{
+			messageType: messageType,
+			body:        make([]byte, length),
+			received:    make([]bool, length),
+			remaining:   length,
+		}
+		s.messages[seq] = message
+	}
+	if message.messageType != messageType || len(message.body) != length {
+		return
+	}
+
+	copy(message.body[offset:], fragment)
+	for i := offset; i < offset+len(fragment); i++ {
+		if !message.received[i] {
+			message.received[i] = true
+			message.remaining--
+		}
+	}
+}
+
+// next returns the next handshake message if all of it has been received.
+func (s *dtlsHandshakeStream) next() (messageType byte, body []byte, ok bool) {
+	message := s.messages[s.nextSeq]
+	if message == nil || message.remaining > 0 {
+		return 0, nil, false
+	}
+	delete(s.messages, s.nextSeq)
+	s.nextSeq++
+	return message.messageType, message.body, true
+}
@@ -0,0 +1,212 @@
+package ja3assembler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// handshakeMessages returns the handshake messages, with their headers, sent by one side of a TLS connection.
+func handshakeMessages(segments []segment, fromClient bool) [][]byte {
+	var stream, handshake []byte
+	for _, s := range segments {
+		if s.fromClient == fromClient {
+			stream = append(stream, s.data...)
+		}
+	}
+	for len(stream) >= recordHeaderLength && stream[0] == recordTypeHandshake {
+		length := int(stream[3])<<8 | int(stream[4])
+		handshake = append(handshake, stream[recordHeaderLength:recordHeaderLength+length]...)
+		stream = stream[recordHeaderLength+length:]
+	}
+
+	messages := [][]byte{}
+	for len(handshake) >= handshakeHeaderLength {
+		length := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+		messages = append(messages, handshake[:handshakeHeaderLength+length])
+		handshake = handshake[handshakeHeaderLength+length:]
+	}
+	return messages
+}
+
+// dtlsFragment returns a DTLS handshake message header and the part of the body from offset to offset+length.
+func dtlsFragment(messageType byte, seq uint16, body []byte, offset, length int) []byte {
+	n := len(body)
+	fragment := []byte{
+		messageType, byte(n >> 16), byte(n >> 8), byte(n),
+		byte(seq >> 8), byte(seq),
+		byte(offset >> 16), byte(offset >> 8), byte(offset),
+		byte(length >> 16), byte(length >> 8), byte(length),
+	}
+	return append(fragment, body[offset:offset+length]...)
+}
+
+// dtlsRecord returns a DTLS 1.2 record in epoch 0 carrying the fragments.
+func dtlsRecord(contentType byte, fragments ...[]byte) []byte {
+	data := bytes.Join(fragments, nil)
+	record := []byte{contentType, 0xfe, 0xfd, 0, 0, 0, 0, 0, 0, 0, 1, byte(len(data) >> 8), byte(len(data))}
+	return append(record, data...)
+}
+
+// dtlsClientHello converts the body of a TLS ClientHello into the body of a DTLS 1.2 one, with a cookie after the session ID.
+func dtlsClientHello(body []byte, cookie []byte) []byte {
+	const sessionIDStart = 2 + 32
+	sessionIDEnd := sessionIDStart + 1 + int(body[sessionIDStart])
+	dtls := []byte{0xfe, 0xfd}
+	dtls = append(dtls, body[2:sessionIDEnd]...)
+	dtls = append(dtls, byte(len(cookie)))
+	dtls = append(dtls, cookie...)
+	return append(dtls, body[sessionIDEnd:]...)
+}
+
+func TestWithoutDTLSCookie(t *testing.T) {
+	// version, random, empty session ID, cipher suites and compression methods
+	want := append([]byte{0xfe, 0xfd}, make([]byte, 32+1+2+2)...)
+	body := dtlsClientHello(want, []byte{1, 2, 3})
+	if got := withoutDTLSCookie(body); !bytes.Equal(got, want) {
+		t.Errorf("withoutDTLSCookie() = %x, want %x", got, want)
+	}
+	body[2+32] = 200 // a session ID longer than the message
+	if got := withoutDTLSCookie(body); !bytes.Equal(got, body) {
+		t.Errorf("withoutDTLSCookie() of a truncated ClientHello = %x, want it unchanged", got)
+	}
+}
+
+// The ClientHello, ServerHello and Certificate are taken from a crypto/tls TLS 1.2 handshake and sent as DTLS messages.
+// The server asks the client to send its ClientHello again with a cookie, and the later messages are fragmented,
+// reordered and retransmitted.
+func TestDTLS(t *testing.T) {
+	certificate := testCertificate(t)
+	segments := tlsHandshake(t,
+		&tls.Config{ServerName: "example.com", InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12},
+		&tls.Config{Certificates: []tls.Certificate{certificate}})
+	clientMessages, serverMessages := handshakeMessages(segments, true), handshakeMessages(segments, false)
+	if clientMessages[0][0] != typeClientHello || serverMessages[0][0] != typeServerHello || serverMessages[1][0] != typeCertificate {
+		t.Fatal("unexpected TLS handshake messages")
+	}
+	tlsClientHello := &clientHelloMsg{}
+	tlsClientHello.unmarshal(clientMessages[0])
+	_, tlsJA3String := calculateJA3(tlsClientHello)
+
+	cookie := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	firstClientHello := dtlsClientHello(clientMessages[0][handshakeHeaderLength:], nil)
+	clientHello := dtlsClientHello(clientMessages[0][handshakeHeaderLength:], cookie)
+	helloVerifyRequest := append([]byte{0xfe, 0xff, byte(len(cookie))}, cookie...)
+	serverHello := append([]byte{}, serverMessages[0][handshakeHeaderLength:]...)
+	serverHello[0], serverHello[1] = 0xfe, 0xfd
+	certificateMessage := serverMessages[1][handshakeHeaderLength:]
+
+	a, b := len(clientHello)/3, 2*len(clientHello)/3
+	half := len(serverHello) / 2
+	handshakes := assemble(udpConnection([]segment{
+		{true, dtlsRecord(recordTypeHandshake, dtlsFragment(typeClientHello, 0, firstClientHello, 0, len(firstClientHello)))},
+		{false, dtlsRecord(recordTypeHandshake, dtlsFragment(typeHelloVerifyRequest, 0, helloVerifyRequest, 0, len(helloVerifyRequest)))},
+		// The second ClientHello's fragments arrive out of order, with a retransmission
+		{true, dtlsRecord(recordTypeHandshake, dtlsFragment(typeClientHello, 1, clientHello, b, len(clientHello)-b), dtlsFragment(typeClientHello, 1, clientHello, 0, a))},
+		{true, bytes.Join([][]byte{
+			dtlsRecord(recordTypeHandshake, dtlsFragment(typeClientHello, 1, clientHello, 0, a)),
+			dtlsRecord(recordTypeHandshake, dtlsFragment(typeClientHello, 1, clientHello, a, b-a)),
+		}, nil)},
+		// The Certificate arrives before all of the ServerHello, which comes before it
+		{false, bytes.Join([][]byte{
+			dtlsRecord(recordTypeHandshake, dtlsFragment(typeCertificate, 2, certificateMessage, 0, len(certificateMessage))),
+			dtlsRecord(recordTypeHandshake, dtlsFragment(typeServerHello, 1, serverHello, 0, half)),
+		}, nil)},
+		{false, dtlsRecord(recordTypeHandshake, dtlsFragment(typeServerHello, 1, serverHello, half, len(serverHello)-half))},
+	}))
+	if len(handshakes) != 1 {
+		t.Fatalf("got %d handshakes, want 1", len(handshakes))
+	}
+
+	h := handshakes[0]
+	want := "65277" + strings.TrimPrefix(tlsJA3String, "771")
+	if h.JA3String != want {
+		t.Errorf("JA3String = %q, want %q", h.JA3String, want)
+	}
+	if h.Transport != TransportDTLS || h.SNI != "example.com" || !strings.HasPrefix(h.JA4, "dd2d") || h.JA3S == "" || !strings.HasPrefix(h.JA4S, "dd2") {
+		t.Errorf("unexpected handshake %+v", h)
+	}
+	if h.HelloLatency != 5*time.Millisecond {
+		t.Errorf("HelloLatency = %v, want 5ms from the first ClientHello to the end of the ServerHello", h.HelloLatency)
+	}
+	sha256Sum := sha256.Sum256(certificate.Certificate[0])
+	if len(h.Certificates) != 1 || h.Certificates[0].SHA256 != hex.EncodeToString(sha256Sum[:]) {
+		t.Errorf("got certificates %+v, want the server's", h.Certificates)
+	}
+}
+
+// A connection must start with a ClientHello in epoch 0.
+func TestDTLSNotClientHello(t *testing.T) {
+	body := make([]byte, 40)
+	tests := []struct {
+		name     string
+		datagram []byte
+	}{
+		{"ServerHello", dtlsRecord(recordTypeHandshake, dtlsFragment(typeServerHello, 0, body, 0, len(body)))},
+		{"ChangeCipherSpec", dtlsRecord(recordTypeChangeCipherSpec, []byte{1})},
+		{"truncated", dtlsRecord(recordTypeHandshake, dtlsFragment(typeClientHello, 0, body, 0, len(body)))[:20]},
+		{"TLS", append([]byte{recordTypeHandshake, 3, 3, 0, 44}, tlsMessage(typeClientHello, body)...)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assembler := NewAssembler(func(Handshake) {})
+			for _, p := range udpConnection([]segment{{true, test.datagram}}) {
+				assembler.Assemble(p)
+			}
+			if n := len(assembler.dtls.connections); n != 0 {
+				t.Errorf("tracking %d connections, want none", n)
+			}
+		})
+	}
+}
+
+func TestDTLSHandshakeStream(t *testing.T) {
+	body := []byte("0123456789")
+	s := dtlsHandshakeStream{}
+
+	s.add(typeCertificate, len(body), 0, 0, body)
+	if _, _, ok := s.next(); ok {
+		t.Error("stream started part way through the handshake")
+	}
+
+	s.add(typeClientHello, len(body), 3, 5, body[5:])
+	s.add(typeClientHello, len(body), 4, 0, body) // the next message
+	s.add(typeClientHello, len(body), 3, 0, body[:3])
+	if _, _, ok := s.next(); ok {
+		t.Error("message returned before all of it was received")
+	}
+	s.add(typeClientHello, len(body), 3, 2, body[2:6]) // overlaps both of the other fragments
+	for _, seq := range []uint16{3, 4} {
+		messageType, got, ok := s.next()
+		if !ok || messageType != typeClientHello || !bytes.Equal(got, body) {
+			t.Errorf("message %d = %v %q, want the reassembled ClientHello", seq, ok, got)
+		}
+	}
+
+	s.add(typeClientHello, len(body), 3, 0, body) // a retransmission
+	s.add(typeClientHello, len(body), 5+maxDTLSPendingMessages, 0, body)
+	s.add(typeClientHello, 2*maxDTLSMessageLength, 5, 0, body)
+	if _, _, ok := s.next(); ok || len(s.messages) != 0 {
+		t.Errorf("kept %d invalid messages", len(s.messages))
+	}
+}
+
+// Buffering messages ahead of the next one mustn't overflow when the sequence numbers are near their maximum.
+func TestDTLSHandshakeStreamHighSeq(t *testing.T) {
+	body := []byte("0123456789")
+	s := dtlsHandshakeStream{}
+
+	s.add(typeClientHello, len(body), math.MaxUint16-1, 0, body[:5])
+	s.add(typeClientHello, len(body), math.MaxUint16, 0, body) // ahead of the first message
+	s.add(typeClientHello, len(body), math.MaxUint16-1, 5, body[5:])
+	for _, seq := range []uint16{math.MaxUint16 - 1, math.MaxUint16} {
+		if _, got, ok := s.next(); !ok || !bytes.Equal(got, body) {
+			t.Errorf("message %d = %v %q, want the ClientHello", seq, ok, got)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package ja3assembler
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// Handshake holds the fingerprints extracted from a single TLS connection, or an SSH or plaintext HTTP
+// connection if SSH, HTTP or HTTP2 is set.
+// Fields for a side of the connection that wasn't seen are left empty.
+type Handshake struct {
+	// Capture time of the packet that completed the first hello seen
+	Time time.Time
+
+	// Connection endpoints. The source is always the client (i.e. the side which sent the ClientHello).
+	SrcIP   net.IP
+	SrcPort uint16
+	DstIP   net.IP
+	DstPort uint16
+
+	// The transport the handshake was carried over, one of "tcp", "quic" or "dtls"
+	Transport string
+
+	// Fingerprints of the TCP SYN and SYN-ACK which opened the connection, if they were seen,
+	// and the IP TTL (or hop limit) each arrived with
+	JA4T      string
+	ClientTTL uint8
+	JA4TS     string
+	ServerTTL uint8
+
+	// Latencies measured from the capture times of packets, zero if the packets involved weren't seen
+	SYNToSYNACK       time.Duration // from the SYN to the SYN-ACK, i.e. the round trip time to the server
+	SYNACKToACK       time.Duration // from the SYN-ACK to the ACK, i.e. the round trip time to the client
+	HelloLatency      time.Duration // from the ClientHello to the ServerHello
+	HandshakeDuration time.Duration // from the start of the connection until the client finished the TLS handshake
+	JA4L              string        // JA4L-C fingerprint of the latency to the client
+	JA4LS             string        // JA4L-S fingerprint of the latency to the server
+
+	// The plaintext protocol that was upgraded to TLS (e.g. smtp or postgres), empty if the connection started with TLS
+	Protocol string
+
+	// Client fingerprints calculated from the ClientHello
+	JA3       string
+	JA3String string // the string JA3 is the MD5 hash of
+	JA4       string
+	JA4r      string // the unhashed JA4_r form of JA4
+	SNI       string
+
+	// Server fingerprints calculated from the ServerHello
+	JA3S       string
+	JA3SString string // the string JA3S is the MD5 hash of
+	JA4S       string
+
+	// The server's certificate chain, leaf first. This is only visible for TLS 1.2 and earlier.
+	Certificates []Certificate
+
+	// Set instead of the TLS fields if this was an SSH connection
+	SSH *SSHHandshake `json:",omitempty"`
+	// Set instead of the TLS fields if this was a plaintext HTTP connection
+	HTTP *HTTPRequest `json:",omitempty"`
+	// Set instead of the TLS fields if this was a cleartext HTTP/2 connection
+	HTTP2 *HTTP2Fingerprint `json:",omitempty"`
+}
+
+// Transports that handshakes can be carried over, as set in Handshake.Transport
+const (
+	TransportTCP  = "tcp"
+	TransportQUIC = "quic"
+	TransportDTLS = "dtls"
+)
+
+// merge fills any fields not already set in h with those from other.
+func (h *Handshake) merge(other Handshake) {
+	if h.JA3 != "" && other.JA3S != "" && !h.Time.IsZero() && !other.Time.IsZero() {
+		// h is the client's side and other the server's so their times are when each hello was seen
+		h.HelloLatency = other.Time.Sub(h.Time)
+	}
+	if h.Time.IsZero() || (!other.Time.IsZero() && other.Time.Before(h.Time)) {
+		h.Time = other.Time
+	}
+	if h.SrcIP == nil {
+		h.SrcIP, h.SrcPort, h.DstIP, h.DstPort = other.SrcIP, other.SrcPort, other.DstIP, other.DstPort
+	}
+	if h.Transport == "" {
+		h.Transport = other.Transport
+	}
+	if h.Protocol == "" || (h.JA3 == "" && other.JA3 != "") {
+		// The client is the most reliable indicator of the protocol as some server greetings are ambiguous
+		h.Protocol = other.Protocol
+	}
+	if h.JA3 == "" {
+		h.JA3, h.JA3String, h.JA4, h.JA4r, h.SNI = other.JA3, other.JA3String, other.JA4, other.JA4r, other.SNI
+	}
+	if h.JA3S == "" {
+		h.JA3S, h.JA3SString, h.JA4S, h.Certificates = other.JA3S, other.JA3SString, other.JA4S, other.Certificates
+	}
+}
+
+// setEndpoints fills in the connection endpoints from the flows of packets sent by the client.
+func (h *Handshake) setEndpoints(netFlow, transportFlow gopacket.Flow) {
+	h.SrcIP, h.DstIP = net.IP(netFlow.Src().Raw()), net.IP(netFlow.Dst().Raw())
+	h.SrcPort, h.DstPort = binary.BigEndian.Uint16(transportFlow.Src().Raw()), binary.BigEndian.Uint16(transportFlow.Dst().Raw())
+}
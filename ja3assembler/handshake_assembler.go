@@ -1,8 +1,10 @@
 package ja3assembler
 
 import (
+	"bytes"
 	"crypto/tls"
 	"fmt"
+	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/tcpassembly"
@@ -14,8 +16,11 @@ const (
 
 	typeClientHello byte = 0x01
 	typeServerHello byte = 0x02
+	typeCertificate byte = 0x0b
 
-	recordTypeHandshake = 0x16
+	recordTypeChangeCipherSpec = 0x14
+	recordTypeHandshake        = 0x16
+	recordTypeApplicationData  = 0x17
 )
 
 // JA3PrinterFactory implements tcpassembly.StreamFactory interface
@@ -54,24 +59,47 @@ func (j *JA3Printer) Reassembled(reassembly []tcpassembly.Reassembly) {
 
 // unidirectionalStream implements tcpassembly.Stream
 type unidirectionalStream struct {
-	bidi *bidirectionalStream // maps to my bidirectional twin.
+	bidi           *bidirectionalStream // maps to my bidirectional twin.
+	net, transport gopacket.Flow        // the direction of this stream
 
 	// TLS handshake data going to be parsed
 	unparsedRecordData []byte
 	rawHello           []byte
 
-	// Calculated JA3(S) hashes. Only one should be populated
-	ja3  string
-	sni  string
-	ja3s string
+	// State of the plaintext part of the stream, for protocols which upgrade to TLS part way through
+	recordsStarted  bool               // if true, the plaintext is over and the rest of the stream is TLS records
+	protocol        *plaintextProtocol // nil if the stream started with TLS
+	client          bool               // whether the plaintext was sent by the client
+	upgraded        bool               // if true, the last plaintext was the switch to TLS
+	plaintextLength int
 
-	succeeded  bool   // if true, one of ja3/ja3s must be set
+	ssh   *sshStream   // set if this is an SSH connection rather than TLS
+	http  *httpStream  // set if this is the client's side of a plaintext HTTP connection
+	http2 *http2Stream // set if this is the client's side of a cleartext HTTP/2 connection
+
+	sentSYN, sentSYNACK bool      // whether the stream started by opening or accepting the connection
+	ja4t                string    // JA4T (or JA4TS) fingerprint of the SYN (or SYN-ACK) the stream started with
+	ttl                 uint8     // TTL of the SYN (or SYN-ACK) the stream started with
+	openedAt            time.Time // capture time of the SYN (or SYN-ACK) the stream started with
+
+	// Fingerprints calculated from this side of the handshake. Only the client or server fields should be populated
+	handshake Handshake
+	lastSeen  time.Time // capture time of the most recently reassembled data
+
+	changeCipherSpecSeen bool      // whether the client has sent a ChangeCipherSpec since its ClientHello
+	helloRetryRequested  bool      // whether the server has sent a HelloRetryRequest instead of its ServerHello
+	handshakeFinished    time.Time // capture time of the client's Finished message
+
+	succeeded  bool   // if true, one of handshake.JA3/JA3S must be set
 	done       bool   // if true, we've seen the last packet we're going to for this stream.
 	doneReason string // just some debugging to see why a stream stopped
 }
 
 // Reassembled handles reassembled TCP stream data.
 func (s *unidirectionalStream) Reassembled(reassembly []tcpassembly.Reassembly) {
+	if n := len(reassembly); n > 0 && reassembly[n-1].Seen.After(s.bidi.lastPacketSeen) {
+		s.bidi.lastPacketSeen = reassembly[n-1].Seen
+	}
 	if s.done {
 		return
 	}
@@ -79,73 +107,191 @@ func (s *unidirectionalStream) Reassembled(reassembly []tcpassembly.Reassembly)
 	for _, packet := range reassembly {
 		if packet.Skip != 0 {
 			// If any bytes have been missed then we have to give up trying to reconstruct the TLS handshake
-			s.completeProcessing(false, "missing packets")
+			s.completeProcessing(s.helloParsed(), "missing packets")
 			return
 		}
 		s.unparsedRecordData = append(s.unparsedRecordData, packet.Bytes...)
+		s.lastSeen = packet.Seen
+	}
+
+	if s.ssh == nil && s.http == nil && s.http2 == nil && s.protocol == nil && !s.recordsStarted {
+		switch {
+		case isSSH(s.unparsedRecordData):
+			s.ssh = &sshStream{}
+		case isHTTP2Preface(s.unparsedRecordData):
+			s.http2 = &http2Stream{}
+		case isHTTPRequest(s.unparsedRecordData):
+			s.http = &httpStream{}
+		case isHTTPResponse(s.unparsedRecordData):
+			// Only the request is fingerprinted
+			s.completeProcessing(false, "HTTP response")
+			return
+		}
+	}
+	if s.ssh != nil {
+		s.parseSSH()
+		return
+	}
+	if s.http2 != nil {
+		s.parseHTTP2()
+		return
+	}
+	if s.http != nil {
+		s.parseHTTP()
+		return
+	}
+
+	if !s.recordsStarted {
+		if !s.parsePlaintext() {
+			return
+		}
+		s.recordsStarted = true
 	}
 
 	// See if there's another record we can decode
-	for len(s.unparsedRecordData) >= recordHeaderLength {
+	for !s.done && len(s.unparsedRecordData) >= recordHeaderLength {
 		recordHeader := s.unparsedRecordData[:5]
 		// Check the record header is roughly valid
+		recordType := recordHeader[0]
 		headerVersion := uint16(recordHeader[1])<<8 | uint16(recordHeader[2])
 		recordLength := int(recordHeader[3])<<8 | int(recordHeader[4])
 		if headerVersion < tls.VersionTLS10 || headerVersion > tls.VersionTLS13 {
 			// Invalid/unsupported record header
-			s.completeProcessing(false, "unsupported record header %x", headerVersion)
+			s.completeProcessing(s.helloParsed(), "unsupported record header %x", headerVersion)
+			return
+		}
+		if s.handshake.JA3 != "" && recordType == recordTypeApplicationData {
+			// In TLS 1.3 the client's Finished message is the first record it encrypts
+			s.handshakeFinished = s.lastSeen
+			s.completeProcessing(true, "success")
 			return
 		}
 
-		// If there's enough record data read "parse" it into the rawHello
-		if len(s.unparsedRecordData) >= recordHeaderLength+recordLength {
-			s.rawHello = append(s.rawHello, s.unparsedRecordData[recordHeaderLength:recordHeaderLength+recordLength]...)
-			s.unparsedRecordData = s.unparsedRecordData[recordHeaderLength+recordLength:]
-		} else {
-			// Otherwise, break the loop
+		if len(s.unparsedRecordData) < recordHeaderLength+recordLength {
+			// Wait for the rest of the record
 			break
 		}
-	}
+		record := s.unparsedRecordData[recordHeaderLength : recordHeaderLength+recordLength]
+		s.unparsedRecordData = s.unparsedRecordData[recordHeaderLength+recordLength:]
 
-	// Check if we've read enough of the handshake to decode it
-	if len(s.rawHello) < handshakeHeaderLength {
-		// Don't even have the handshake header yet
-		return
+		if s.handshake.JA3 != "" {
+			// All that's left to see is when the client finishes the handshake
+			switch {
+			case recordType == recordTypeChangeCipherSpec:
+				s.changeCipherSpecSeen = true
+				continue
+			case recordType == recordTypeHandshake && (!s.changeCipherSpecSeen || isClientHelloRecord(record)):
+				// e.g. the ClientKeyExchange, or the ClientHello sent again after a TLS 1.3 HelloRetryRequest
+				continue
+			case recordType == recordTypeHandshake:
+				// Before TLS 1.3 the Finished message is the first to be encrypted, straight after the ChangeCipherSpec
+				s.handshakeFinished = s.lastSeen
+				s.completeProcessing(true, "success")
+			default:
+				// e.g. an alert because the handshake failed
+				s.completeProcessing(true, "unexpected record type %x", recordType)
+			}
+			return
+		}
+		if recordType == recordTypeChangeCipherSpec && s.helloRetryRequested && !s.helloParsed() {
+			// Sent straight after a HelloRetryRequest for middlebox compatibility (RFC 8446, Appendix D.4)
+			continue
+		}
+		if recordType != recordTypeHandshake {
+			// e.g. a ChangeCipherSpec: the unencrypted part of the handshake is over
+			s.completeProcessing(s.helloParsed(), "unexpected record type %x", recordType)
+			return
+		}
+		s.rawHello = append(s.rawHello, record...)
+		s.parseHandshakeMessages()
 	}
+}
 
-	helloType := s.rawHello[0]
-	if helloType != typeClientHello && helloType != typeServerHello {
-		// This is not an expected/supported handshake message
-		s.completeProcessing(false, "unexpected handshake type")
-		return
-	}
+// isClientHelloRecord returns whether a handshake record holds a whole plaintext ClientHello.
+// An encrypted Finished message is very unlikely to start with a matching type and length.
+func isClientHelloRecord(record []byte) bool {
+	return len(record) >= handshakeHeaderLength && record[0] == typeClientHello &&
+		int(record[1])<<16|int(record[2])<<8|int(record[3]) == len(record)-handshakeHeaderLength
+}
 
-	helloLength := int(s.rawHello[1])<<16 | int(s.rawHello[2])<<8 | int(s.rawHello[3])
-	switch {
-	case len(s.rawHello) < handshakeHeaderLength+helloLength:
-		// Not enough rawHello data yet
-		return
-	case helloLength > 2<<16:
-		// hello too large
-		s.completeProcessing(false, "hello too large")
-		return
+// parseHandshakeMessages parses all the complete handshake messages read so far.
+func (s *unidirectionalStream) parseHandshakeMessages() {
+	for !s.done && len(s.rawHello) >= handshakeHeaderLength {
+		messageType := s.rawHello[0]
+		messageLength := int(s.rawHello[1])<<16 | int(s.rawHello[2])<<8 | int(s.rawHello[3])
+		switch {
+		case messageLength > 2<<16:
+			s.completeProcessing(s.helloParsed(), "handshake message too large")
+			return
+		case len(s.rawHello) < handshakeHeaderLength+messageLength:
+			// Not enough data yet
+			return
+		}
+
+		message := s.rawHello[:handshakeHeaderLength+messageLength]
+		s.rawHello = s.rawHello[handshakeHeaderLength+messageLength:]
+		s.parseHandshakeMessage(messageType, message)
 	}
+}
 
-	// Have now decoded a single handshake message of either clientHello or serverHello type
-	switch helloType {
-	case typeClientHello:
+func (s *unidirectionalStream) parseHandshakeMessage(messageType byte, message []byte) {
+	switch {
+	case messageType == typeClientHello && !s.helloParsed():
 		msg := &clientHelloMsg{}
-		msg.unmarshal(s.rawHello[:handshakeHeaderLength+helloLength])
-		s.sni = msg.serverName
-		s.ja3 = calculateJA3(msg)
-	case typeServerHello:
+		msg.unmarshal(message)
+		s.handshake.SNI = msg.serverName
+		s.handshake.JA3, s.handshake.JA3String = calculateJA3(msg)
+		s.handshake.JA4, s.handshake.JA4r = calculateJA4(msg, TransportTCP)
+		s.handshake.setEndpoints(s.net, s.transport)
+		s.handshake.Time = s.lastSeen
+		s.handshake.Transport = TransportTCP
+		s.setProtocol()
+		// Keep reading until the client's Finished message so that how long the handshake took can be measured
+
+	case messageType == typeServerHello && !s.helloParsed():
 		msg := &serverHelloMsg{}
-		msg.unmarshal(s.rawHello[:handshakeHeaderLength+helloLength])
-		s.ja3s = calculateJA3S(msg)
+		msg.unmarshal(message)
+		if bytes.Equal(msg.random, helloRetryRequestRandom) {
+			// A HelloRetryRequest is followed by another ClientHello and then the real ServerHello
+			s.helloRetryRequested = true
+			return
+		}
+		s.handshake.JA3S, s.handshake.JA3SString = calculateJA3S(msg)
+		s.handshake.JA4S = calculateJA4S(msg, TransportTCP)
+		s.handshake.setEndpoints(s.net.Reverse(), s.transport.Reverse())
+		s.handshake.Time = s.lastSeen
+		s.handshake.Transport = TransportTCP
+		s.setProtocol()
+		if msg.supportedVersion >= tls.VersionTLS13 {
+			// Everything after the ServerHello is encrypted in TLS 1.3
+			s.completeProcessing(true, "success")
+		}
+		// Otherwise, the server's certificate should follow
+
+	case messageType == typeCertificate && s.handshake.JA3S != "":
+		s.handshake.Certificates = parseCertificateMessage(message)
+		s.completeProcessing(true, "success")
+
+	case s.helloParsed():
+		// The server didn't send a certificate, e.g. because this is a resumed session
+		s.completeProcessing(true, "no certificate")
+
 	default:
-		panic("unknown hello type")
+		// This is not an expected/supported handshake message
+		s.completeProcessing(false, "unexpected handshake type")
 	}
-	s.completeProcessing(true, "success")
+}
+
+// setProtocol records which plaintext protocol, if any, the stream was upgraded from.
+func (s *unidirectionalStream) setProtocol() {
+	if s.protocol != nil {
+		s.handshake.Protocol = s.protocol.name
+	}
+}
+
+// helloParsed returns whether this stream's ClientHello or ServerHello (or SSH banner) has been parsed.
+func (s *unidirectionalStream) helloParsed() bool {
+	return s.handshake.JA3 != "" || s.handshake.JA3S != "" || (s.ssh != nil && s.ssh.banner != "")
 }
 
 // ReassemblyComplete marks this stream as finished.
@@ -155,7 +301,7 @@ func (s *unidirectionalStream) ReassemblyComplete() {
 		return
 	}
 
-	s.completeProcessing(false, "ReassemblyComplete()")
+	s.completeProcessing(s.helloParsed(), "ReassemblyComplete()")
 }
 
 func (s *unidirectionalStream) completeProcessing(success bool, reason string, args ...interface{}) {
@@ -170,13 +316,3 @@ func (s *unidirectionalStream) completeProcessing(success bool, reason string, a
 	s.rawHello = nil
 	s.bidi.maybeFinish()
 }
-
-//Assembler handles reassembling TCP streams.
-func NewAssembler(callback func(ja3, ja3s, sni string)) *tcpassembly.Assembler {
-	return tcpassembly.NewAssembler(
-		tcpassembly.NewStreamPool(
-			&assembler{
-				callback:         callback,
-				unmatchedStreams: map[key]*bidirectionalStream{},
-			}))
-}
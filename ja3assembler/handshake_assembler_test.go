@@ -0,0 +1,78 @@
+package ja3assembler
+
+import (
+	"bytes"
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestHandshakeFinished(t *testing.T) {
+	for name, version := range map[string]uint16{"TLS 1.2": tls.VersionTLS12, "TLS 1.3": tls.VersionTLS13} {
+		t.Run(name, func(t *testing.T) {
+			// The client doesn't send any application data and the connection is never closed
+			segments := testTLSHandshake(t, version)
+			packets := tcpConnection(segments)
+
+			var handshakes []Handshake
+			assembler := NewAssembler(func(handshake Handshake) {
+				handshakes = append(handshakes, handshake)
+			})
+			for _, p := range packets {
+				assembler.Assemble(p)
+			}
+			if len(handshakes) != 1 {
+				t.Fatalf("got %d handshakes before the connection closed, want 1", len(handshakes))
+			}
+
+			// The client's Finished message is in the last thing it sent
+			finished := 0
+			for i, s := range segments {
+				if s.fromClient {
+					finished = i
+				}
+			}
+			syn := packets[0].Metadata().Timestamp
+			want := packets[3+finished].Metadata().Timestamp.Sub(syn)
+			if got := handshakes[0].HandshakeDuration; got != want {
+				t.Errorf("got handshake duration %v, want %v", got, want)
+			}
+			if got := handshakes[0].HelloLatency; got != time.Millisecond {
+				t.Errorf("got hello latency %v, want 1ms", got)
+			}
+		})
+	}
+}
+
+// A server without a key share for any of the groups the client guessed asks for another with a HelloRetryRequest.
+func TestHelloRetryRequest(t *testing.T) {
+	clientConfig := &tls.Config{ServerName: "example.com", InsecureSkipVerify: true}
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}, CurvePreferences: []tls.CurveID{tls.CurveP384}}
+	segments := tlsHandshake(t, clientConfig, serverConfig)
+
+	// The server's first segment is the HelloRetryRequest, and the next that starts with a handshake record
+	// (rather than the ChangeCipherSpec which may follow the HelloRetryRequest) has the real ServerHello
+	serverHello := -1
+	for i, s := range segments[2:] {
+		if !s.fromClient && s.data[0] == recordTypeHandshake {
+			serverHello = 2 + i
+			break
+		}
+	}
+	if segments[1].fromClient || !bytes.Contains(segments[1].data, helloRetryRequestRandom) || serverHello < 0 {
+		t.Fatal("the server didn't send a HelloRetryRequest and then a ServerHello")
+	}
+
+	handshakes := assemble(tcpConnection(segments))
+	if len(handshakes) != 1 {
+		t.Fatalf("got %d handshakes, want 1", len(handshakes))
+	}
+	h := handshakes[0]
+	if h.JA3 == "" || h.JA3S == "" {
+		t.Errorf("got JA3 %q and JA3S %q, want both", h.JA3, h.JA3S)
+	}
+	// Packets are a millisecond apart
+	if want := time.Duration(serverHello) * time.Millisecond; h.HelloLatency != want {
+		t.Errorf("HelloLatency = %v, want the %v until the real ServerHello", h.HelloLatency, want)
+	}
+}
@@ -0,0 +1,195 @@
+package ja3assembler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// testStart is the capture time of the first packet of every test connection.
+var testStart = time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+
+// segment is the data sent in one go by one side of a test connection.
+type segment struct {
+	fromClient bool
+	data       []byte
+}
+
+// recordingConn records everything written to a connection.
+type recordingConn struct {
+	net.Conn
+	fromClient bool
+	mu         *sync.Mutex
+	segments   *[]segment
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	*c.segments = append(*c.segments, segment{c.fromClient, append([]byte{}, b...)})
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+// tlsHandshake returns what a crypto/tls client and server send each other during a handshake.
+func tlsHandshake(t *testing.T, clientConfig, serverConfig *tls.Config) []segment {
+	// Unlike net.Pipe, a real connection is buffered so both sides can write at once, e.g. after a HelloRetryRequest
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var segments []segment
+	client := tls.Client(&recordingConn{clientConn, true, &mu, &segments}, clientConfig)
+	server := tls.Server(&recordingConn{serverConn, false, &mu, &segments}, serverConfig)
+
+	serverErr := make(chan error)
+	go func() {
+		serverErr <- server.Handshake()
+	}()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-serverErr; err != nil {
+		t.Fatal(err)
+	}
+	clientConn.Close()
+	serverConn.Close()
+	return segments
+}
+
+// testCertificate returns a self-signed certificate for example.com.
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com", Organization: []string{"osquery-ja3 test"}},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    testStart,
+		NotAfter:     testStart.AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// testTLSHandshake returns what a crypto/tls client and server send each other during a handshake using the given TLS version.
+func testTLSHandshake(t *testing.T, version uint16) []segment {
+	clientConfig := &tls.Config{ServerName: "example.com", InsecureSkipVerify: true, MaxVersion: version}
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}
+	return tlsHandshake(t, clientConfig, serverConfig)
+}
+
+// tcpConnection returns the packets of a TCP connection from 10.0.0.1:50000 to 10.0.0.2:443 which
+// carries the segments, starting with the three-way handshake. Packets are a millisecond apart.
+func tcpConnection(segments []segment) []gopacket.Packet {
+	clientSeq, serverSeq := uint32(1000), uint32(5000)
+	timestamp := testStart
+	packet := func(fromClient bool, tcp *layers.TCP, payload []byte) gopacket.Packet {
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+		tcp.SrcPort, tcp.DstPort, tcp.Seq, tcp.Ack, tcp.Window = 50000, 443, clientSeq, serverSeq, 64240
+		if !fromClient {
+			ip.TTL, ip.SrcIP, ip.DstIP = 56, ip.DstIP, ip.SrcIP
+			tcp.SrcPort, tcp.DstPort, tcp.Seq, tcp.Ack, tcp.Window = 443, 50000, serverSeq, clientSeq, 65160
+		}
+		length := uint32(len(payload))
+		if tcp.SYN || tcp.FIN {
+			length++
+		}
+		if fromClient {
+			clientSeq += length
+		} else {
+			serverSeq += length
+		}
+
+		p := serialize(ip, tcp, payload, timestamp)
+		timestamp = timestamp.Add(time.Millisecond)
+		return p
+	}
+
+	packets := []gopacket.Packet{
+		packet(true, &layers.TCP{SYN: true, Options: []layers.TCPOption{{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}}}}, nil),
+		packet(false, &layers.TCP{SYN: true, ACK: true}, nil),
+		packet(true, &layers.TCP{ACK: true}, nil),
+	}
+	for _, s := range segments {
+		packets = append(packets, packet(s.fromClient, &layers.TCP{ACK: true, PSH: true}, s.data))
+	}
+	return packets
+}
+
+// udpConnection returns the packets of a UDP flow between 10.0.0.1:50000 and 10.0.0.2:443 with a datagram
+// carrying each segment. Packets are a millisecond apart.
+func udpConnection(segments []segment) []gopacket.Packet {
+	packets := []gopacket.Packet{}
+	timestamp := testStart
+	for _, s := range segments {
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+		udp := &layers.UDP{SrcPort: 50000, DstPort: 443}
+		if !s.fromClient {
+			ip.TTL, ip.SrcIP, ip.DstIP = 56, ip.DstIP, ip.SrcIP
+			udp.SrcPort, udp.DstPort = udp.DstPort, udp.SrcPort
+		}
+		packets = append(packets, serialize(ip, udp, s.data, timestamp))
+		timestamp = timestamp.Add(time.Millisecond)
+	}
+	return packets
+}
+
+// serialize returns a captured Ethernet frame carrying the layers.
+func serialize(ip *layers.IPv4, transport gopacket.SerializableLayer, payload []byte, timestamp time.Time) gopacket.Packet {
+	switch transport := transport.(type) {
+	case *layers.TCP:
+		transport.SetNetworkLayerForChecksum(ip)
+	case *layers.UDP:
+		transport.SetNetworkLayerForChecksum(ip)
+	}
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2}, EthernetType: layers.EthernetTypeIPv4}
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, eth, ip, transport, gopacket.Payload(payload))
+	if err != nil {
+		panic(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	p.Metadata().Timestamp = timestamp
+	return p
+}
+
+// assemble returns every handshake reassembled from the packets, once the assembler has been flushed.
+func assemble(packets []gopacket.Packet) []Handshake {
+	var handshakes []Handshake
+	assembler := NewAssembler(func(handshake Handshake) {
+		handshakes = append(handshakes, handshake)
+	})
+	for _, p := range packets {
+		assembler.Assemble(p)
+	}
+	assembler.FlushAll()
+	return handshakes
+}
@@ -0,0 +1,155 @@
+package ja3assembler
+
+import (
+	"bytes"
+	"strings"
+)
+
+// maxHTTPHeaderLength limits how much of a request is buffered waiting for the end of its headers.
+const maxHTTPHeaderLength = 64 << 10
+
+// httpMethods are the request methods recognised at the start of a plaintext HTTP connection.
+var httpMethods = []string{"GET ", "POST ", "HEAD ", "PUT ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+
+// HTTPRequest holds the fingerprint of the first request sent over a plaintext HTTP/1.x connection.
+type HTTPRequest struct {
+	Method    string
+	Host      string
+	UserAgent string
+
+	// JA4H fingerprint calculated from the request line and headers
+	// Spec: https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4H.md
+	JA4H  string
+	JA4Hr string // the unhashed JA4H_r form of JA4H
+}
+
+// httpStream holds what has been parsed from the client's side of an HTTP connection.
+type httpStream struct {
+	request *HTTPRequest // nil until the end of the request headers
+}
+
+// httpHeader is a single request header, with the name as it was sent.
+type httpHeader struct {
+	name, value string
+}
+
+func isHTTPRequest(data []byte) bool {
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(data, []byte(method)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHTTPResponse(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("HTTP/1."))
+}
+
+// parseHTTP waits for the end of the request headers, after which everything interesting has been seen.
+func (s *unidirectionalStream) parseHTTP() {
+	var lines [][]byte
+	data := s.unparsedRecordData
+	for {
+		n := nextLine(data)
+		if n == 0 {
+			if len(s.unparsedRecordData) > maxHTTPHeaderLength {
+				s.completeProcessing(false, "HTTP headers too long")
+			}
+			return
+		}
+		line := bytes.TrimRight(data[:n], "\r\n")
+		data = data[n:]
+		if len(line) == 0 {
+			// The blank line at the end of the headers
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	request, upgrade := parseHTTPRequest(lines)
+	if request == nil {
+		s.completeProcessing(false, "invalid HTTP request %q", lines[0])
+		return
+	}
+	s.http.request = request
+	s.handshake.HTTP = request
+	s.handshake.setEndpoints(s.net, s.transport)
+	s.handshake.Time = s.lastSeen
+	s.handshake.Transport = TransportTCP
+	if isH2CUpgrade(upgrade) {
+		// If the server agrees, the client follows the request with the HTTP/2 connection preface
+		s.unparsedRecordData = data
+		s.http2 = &http2Stream{}
+		s.parseHTTP2()
+		return
+	}
+	s.completeProcessing(true, "success")
+}
+
+// parseHTTPRequest parses the request line and headers of an HTTP/1.x request, returning nil if it's invalid.
+// The value of any Upgrade header is also returned.
+func parseHTTPRequest(lines [][]byte) (request *HTTPRequest, upgrade string) {
+	// Request-Line = Method SP Request-URI SP HTTP-Version (RFC 7230, Section 3.1.1)
+	requestLine := strings.Split(string(lines[0]), " ")
+	if len(requestLine) != 3 || !strings.HasPrefix(requestLine[2], "HTTP/1.") {
+		return nil, ""
+	}
+	method, version := requestLine[0], requestLine[2]
+
+	var headers []httpHeader
+	for _, line := range lines[1:] {
+		if line[0] == ' ' || line[0] == '\t' {
+			// A deprecated continuation of the previous header's value
+			if len(headers) > 0 {
+				headers[len(headers)-1].value += " " + string(bytes.TrimSpace(line))
+			}
+			continue
+		}
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 {
+			continue
+		}
+		headers = append(headers, httpHeader{
+			name:  string(line[:colon]),
+			value: string(bytes.TrimSpace(line[colon+1:])),
+		})
+	}
+
+	request = &HTTPRequest{Method: method}
+	for _, header := range headers {
+		switch strings.ToLower(header.name) {
+		case "host":
+			if request.Host == "" {
+				request.Host = header.value
+			}
+		case "user-agent":
+			if request.UserAgent == "" {
+				request.UserAgent = header.value
+			}
+		case "upgrade":
+			upgrade = header.value
+		}
+	}
+	request.JA4H, request.JA4Hr = calculateJA4H(method, version, headers)
+	return request, upgrade
+}
+
+// finishHTTP reports the fingerprints of an HTTP connection once both directions have finished.
+// A connection upgraded from HTTP/1.1 to HTTP/2 is reported once for each.
+func (bd *bidirectionalStream) finishHTTP() {
+	client := bd.a
+	if client.http == nil && client.http2 == nil {
+		client = bd.b
+	}
+	if client.http != nil && client.http.request != nil {
+		handshake := client.handshake
+		handshake.HTTP2 = nil
+		bd.report(handshake)
+	}
+	if client.http2 != nil && client.http2.fingerprint != nil {
+		handshake := client.handshake
+		handshake.HTTP = nil
+		bd.report(handshake)
+	}
+}
@@ -0,0 +1,265 @@
+package ja3assembler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// http2Preface is sent by the client at the start of every HTTP/2 connection (RFC 7540, Section 3.5).
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	http2FrameHeaderLength = 9
+	// maxHTTP2FrameLength is the largest frame allowed before the server has acknowledged any larger SETTINGS_MAX_FRAME_SIZE.
+	maxHTTP2FrameLength = 16384
+
+	http2FrameHeaders      = 0x1
+	http2FramePriority     = 0x2
+	http2FrameSettings     = 0x4
+	http2FrameWindowUpdate = 0x8
+
+	http2FlagAck      = 0x1
+	http2FlagPadded   = 0x8
+	http2FlagPriority = 0x20
+)
+
+// hpackStaticPseudoHeaders maps the indexes of the pseudo-headers in the HPACK static table (RFC 7541, Appendix A) to their names.
+var hpackStaticPseudoHeaders = map[int]string{
+	1: ":authority",
+	2: ":method", 3: ":method",
+	4: ":path", 5: ":path",
+	6: ":scheme", 7: ":scheme",
+	8: ":status", 9: ":status", 10: ":status", 11: ":status", 12: ":status", 13: ":status", 14: ":status",
+}
+
+// HTTP2Fingerprint holds the Akamai fingerprint of the start of a client's cleartext HTTP/2 (h2c) connection.
+// Spec: https://www.blackhat.com/docs/eu-17/materials/eu-17-Shuster-Passive-Fingerprinting-Of-HTTP2-Clients-wp.pdf
+type HTTP2Fingerprint struct {
+	Settings          string // the SETTINGS parameters in the order they were sent, e.g. 1:65536;2:0;4:6291456;6:262144
+	WindowUpdate      string // the increment of the first connection WINDOW_UPDATE, or 00 if none was sent
+	Priority          string // each PRIORITY frame sent before the first request, or 0 if none were sent
+	PseudoHeaderOrder string // the order of the pseudo-headers in the first request, e.g. m,a,s,p
+
+	Akamai     string // the full fingerprint: Settings|WindowUpdate|Priority|PseudoHeaderOrder
+	AkamaiHash string // the MD5 hash of Akamai
+}
+
+// http2Stream holds what has been parsed from the client's side of a cleartext HTTP/2 connection.
+type http2Stream struct {
+	prefaceSeen  bool
+	settings     []string
+	windowUpdate string
+	priorities   []string
+	fingerprint  *HTTP2Fingerprint // nil until the first HEADERS frame
+}
+
+func isHTTP2Preface(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("PRI * HTTP/2.0"))
+}
+
+// isH2CUpgrade returns whether an Upgrade header asks to switch to cleartext HTTP/2.
+func isH2CUpgrade(upgrade string) bool {
+	for _, protocol := range strings.Split(upgrade, ",") {
+		if strings.EqualFold(strings.TrimSpace(protocol), "h2c") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHTTP2 reads the client's frames up to its first HEADERS frame, after which everything interesting has been seen.
+func (s *unidirectionalStream) parseHTTP2() {
+	if !s.http2.prefaceSeen {
+		if len(s.unparsedRecordData) < len(http2Preface) {
+			return
+		}
+		if !bytes.HasPrefix(s.unparsedRecordData, []byte(http2Preface)) {
+			// e.g. the server refused to upgrade to HTTP/2
+			s.completeProcessing(false, "invalid HTTP/2 preface")
+			return
+		}
+		s.unparsedRecordData = s.unparsedRecordData[len(http2Preface):]
+		s.http2.prefaceSeen = true
+	}
+
+	for len(s.unparsedRecordData) >= http2FrameHeaderLength {
+		header := s.unparsedRecordData[:http2FrameHeaderLength]
+		length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+		frameType, flags := header[3], header[4]
+		streamID := binary.BigEndian.Uint32(header[5:]) & 0x7fffffff
+		if length > maxHTTP2FrameLength {
+			s.completeProcessing(false, "HTTP/2 frame too large")
+			return
+		}
+		if len(s.unparsedRecordData) < http2FrameHeaderLength+length {
+			// Wait for the rest of the frame
+			return
+		}
+		payload := s.unparsedRecordData[http2FrameHeaderLength : http2FrameHeaderLength+length]
+		s.unparsedRecordData = s.unparsedRecordData[http2FrameHeaderLength+length:]
+
+		switch frameType {
+		case http2FrameSettings:
+			if flags&http2FlagAck != 0 || streamID != 0 {
+				continue
+			}
+			for ; len(payload) >= 6; payload = payload[6:] {
+				s.http2.settings = append(s.http2.settings,
+					fmt.Sprintf("%d:%d", binary.BigEndian.Uint16(payload), binary.BigEndian.Uint32(payload[2:])))
+			}
+
+		case http2FrameWindowUpdate:
+			if streamID == 0 && s.http2.windowUpdate == "" && len(payload) == 4 {
+				s.http2.windowUpdate = fmt.Sprint(binary.BigEndian.Uint32(payload) & 0x7fffffff)
+			}
+
+		case http2FramePriority:
+			if len(payload) == 5 {
+				// Stream dependency with the exclusive flag in its top bit, then the weight minus one
+				dependency := binary.BigEndian.Uint32(payload)
+				s.http2.priorities = append(s.http2.priorities,
+					fmt.Sprintf("%d:%d:%d:%d", streamID, dependency>>31, dependency&0x7fffffff, int(payload[4])+1))
+			}
+
+		case http2FrameHeaders:
+			if flags&http2FlagPadded != 0 {
+				if len(payload) < 1 || int(payload[0]) >= len(payload) {
+					s.completeProcessing(false, "invalid HTTP/2 HEADERS frame")
+					return
+				}
+				payload = payload[1 : len(payload)-int(payload[0])]
+			}
+			if flags&http2FlagPriority != 0 {
+				if len(payload) < 5 {
+					s.completeProcessing(false, "invalid HTTP/2 HEADERS frame")
+					return
+				}
+				payload = payload[5:]
+			}
+			s.http2.fingerprint = s.http2.akamaiFingerprint(hpackPseudoHeaders(payload))
+			s.handshake.HTTP2 = s.http2.fingerprint
+			s.handshake.setEndpoints(s.net, s.transport)
+			if s.handshake.Time.IsZero() {
+				s.handshake.Time = s.lastSeen
+			}
+			s.handshake.Transport = TransportTCP
+			s.completeProcessing(true, "success")
+			return
+		}
+	}
+}
+
+// akamaiFingerprint returns the fingerprint of the frames sent before the first request, which had the given pseudo-headers.
+func (h *http2Stream) akamaiFingerprint(pseudoHeaders []string) *HTTP2Fingerprint {
+	f := &HTTP2Fingerprint{
+		Settings:     strings.Join(h.settings, ";"),
+		WindowUpdate: h.windowUpdate,
+		Priority:     strings.Join(h.priorities, ","),
+	}
+	if f.WindowUpdate == "" {
+		f.WindowUpdate = "00"
+	}
+	if f.Priority == "" {
+		f.Priority = "0"
+	}
+	order := []string{}
+	for _, name := range pseudoHeaders {
+		// Abbreviated to the first letter of the name, e.g. m for :method
+		order = append(order, name[1:2])
+	}
+	f.PseudoHeaderOrder = strings.Join(order, ",")
+
+	f.Akamai = strings.Join([]string{f.Settings, f.WindowUpdate, f.Priority, f.PseudoHeaderOrder}, "|")
+	f.AkamaiHash = md5Hex(f.Akamai)
+	return f
+}
+
+// hpackPseudoHeaders returns the names of the pseudo-headers at the start of the first HPACK header block
+// on a connection (RFC 7541, Section 6). This is only a partial decoder: nothing can have been added to the
+// dynamic table yet and the values (and any Huffman encoded names) aren't needed so are skipped over.
+func hpackPseudoHeaders(block []byte) []string {
+	names := []string{}
+	for len(block) > 0 {
+		var name string
+		switch first := block[0]; {
+		case first&0x80 != 0: // Indexed header field
+			index, n := readHPACKInteger(block, 7)
+			if n == 0 {
+				return names
+			}
+			block = block[n:]
+			name = hpackStaticPseudoHeaders[index]
+
+		case first&0xe0 == 0x20: // Dynamic table size update
+			_, n := readHPACKInteger(block, 5)
+			if n == 0 {
+				return names
+			}
+			block = block[n:]
+			continue
+
+		default: // Literal header field, with or without indexing
+			prefixBits := 4
+			if first&0xc0 == 0x40 {
+				prefixBits = 6
+			}
+			index, n := readHPACKInteger(block, prefixBits)
+			if n == 0 {
+				return names
+			}
+			block = block[n:]
+			if index == 0 {
+				literal, huffman, n := readHPACKString(block)
+				if n == 0 || huffman {
+					return names
+				}
+				name, block = string(literal), block[n:]
+			} else {
+				name = hpackStaticPseudoHeaders[index]
+			}
+			if _, _, n = readHPACKString(block); n == 0 {
+				return names
+			}
+			block = block[n:]
+		}
+
+		if !strings.HasPrefix(name, ":") || len(name) < 2 {
+			// Pseudo-headers must come before every other header
+			return names
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// readHPACKInteger reads an integer with an N-bit prefix (RFC 7541, Section 5.1), also returning how many bytes
+// it took up. The length is zero if the integer is incomplete or implausibly large.
+func readHPACKInteger(data []byte, prefixBits int) (value, length int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	max := 1<<uint(prefixBits) - 1
+	value = int(data[0]) & max
+	if value < max {
+		return value, 1
+	}
+	for i := 1; i < len(data) && i <= 4; i++ {
+		value += int(data[i]&0x7f) << uint(7*(i-1))
+		if data[i]&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// readHPACKString reads a string literal (RFC 7541, Section 5.2) without decoding it, also returning whether it's
+// Huffman encoded and how many bytes it took up. The length is zero if the string is incomplete.
+func readHPACKString(data []byte) (value []byte, huffman bool, length int) {
+	stringLength, n := readHPACKInteger(data, 7)
+	if n == 0 || len(data) < n+stringLength {
+		return nil, false, 0
+	}
+	return data[n : n+stringLength], data[0]&0x80 != 0, n + stringLength
+}
@@ -0,0 +1,228 @@
+package ja3assembler
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// http2Frame returns an HTTP/2 frame carrying the payload.
+func http2Frame(frameType, flags byte, streamID uint32, payload []byte) []byte {
+	frame := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), frameType, flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[5:], streamID)
+	return append(frame, payload...)
+}
+
+// http2Settings returns a SETTINGS frame with the parameters, given as pairs of identifiers and values.
+func http2Settings(parameters ...uint32) []byte {
+	payload := []byte{}
+	for i := 0; i+1 < len(parameters); i += 2 {
+		parameter := make([]byte, 6)
+		binary.BigEndian.PutUint16(parameter, uint16(parameters[i]))
+		binary.BigEndian.PutUint32(parameter[2:], parameters[i+1])
+		payload = append(payload, parameter...)
+	}
+	return http2Frame(http2FrameSettings, 0, 0, payload)
+}
+
+// chromeHeaderBlock is the HPACK encoding of the start of a request with Chrome's order of pseudo-headers:
+// a dynamic table size update, :method GET, :authority example.com, :scheme https, :path / and then user-agent.
+var chromeHeaderBlock = []byte{
+	0x3f, 0xe1, 0x1f, // dynamic table size update to 4096
+	0x82,                                                            // indexed :method GET
+	0x41, 11, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm', // :authority with incremental indexing
+	0x87,                   // indexed :scheme https
+	0x84,                   // indexed :path /
+	0x7a, 3, 'a', 'b', 'c', // user-agent with incremental indexing
+}
+
+// chromeHTTP2Start returns what Chrome sends at the start of an HTTP/2 connection, up to and including its first HEADERS frame.
+// The HEADERS frame is padded and has a priority so that both have to be skipped to read the header block.
+func chromeHTTP2Start() []byte {
+	headers := append([]byte{2}, 0x80, 0, 0, 0, 255) // pad length, then an exclusive dependency on stream 0 with weight 256
+	headers = append(headers, chromeHeaderBlock...)
+	headers = append(headers, 0, 0)
+	data := []byte(http2Preface)
+	data = append(data, http2Settings(1, 65536, 2, 0, 4, 6291456, 6, 262144)...)
+	data = append(data, http2Frame(http2FrameWindowUpdate, 0, 0, []byte{0, 0xef, 0, 0x01})...)
+	return append(data, http2Frame(http2FrameHeaders, 0x4|http2FlagPadded|http2FlagPriority, 1, headers)...)
+}
+
+// The expected fingerprint is the one published for Chrome, and the hashes were calculated by an independent implementation.
+func TestHTTP2(t *testing.T) {
+	client := chromeHTTP2Start()
+	// The server's SETTINGS arrive while the client is still sending its frames
+	handshakes := assemble(tcpConnection([]segment{
+		{true, client[:10]},
+		{true, client[10:40]},
+		{false, http2Settings(3, 100)},
+		{true, client[40:]},
+	}))
+	if len(handshakes) != 1 {
+		t.Fatalf("got %d handshakes, want 1", len(handshakes))
+	}
+
+	h := handshakes[0]
+	if h.SrcIP.String() != "10.0.0.1" || h.SrcPort != 50000 || h.DstIP.String() != "10.0.0.2" || h.DstPort != 443 {
+		t.Errorf("handshake from %s:%d to %s:%d, want from the client", h.SrcIP, h.SrcPort, h.DstIP, h.DstPort)
+	}
+	if h.HTTP2 == nil || h.HTTP != nil || h.Transport != TransportTCP || h.JA4T == "" {
+		t.Fatalf("unexpected handshake %+v", h)
+	}
+	want := HTTP2Fingerprint{
+		Settings:          "1:65536;2:0;4:6291456;6:262144",
+		WindowUpdate:      "15663105",
+		Priority:          "0",
+		PseudoHeaderOrder: "m,a,s,p",
+		Akamai:            "1:65536;2:0;4:6291456;6:262144|15663105|0|m,a,s,p",
+		AkamaiHash:        "52d84b11737d980aef856699f885ca86",
+	}
+	if *h.HTTP2 != want {
+		t.Errorf("HTTP2 = %+v, want %+v", *h.HTTP2, want)
+	}
+}
+
+func TestHTTP2Frames(t *testing.T) {
+	headers := func(block []byte) []byte { return http2Frame(http2FrameHeaders, 0x4, 1, block) }
+	tests := []struct {
+		name   string
+		frames [][]byte
+		akamai string
+	}{
+		{
+			name: "PRIORITY frames",
+			frames: [][]byte{
+				http2Settings(1, 65536),
+				http2Frame(http2FramePriority, 0, 3, []byte{0, 0, 0, 0, 200}),
+				http2Frame(http2FramePriority, 0, 5, []byte{0x80, 0, 0, 3, 0}),
+				headers([]byte{0x82, 0x84}),
+			},
+			akamai: "1:65536|00|3:0:0:201,5:1:3:1|m,p",
+		},
+		{
+			name: "ignored frames",
+			frames: [][]byte{
+				http2Settings(3, 100, 4, 65535),
+				http2Frame(http2FrameSettings, http2FlagAck, 0, nil),
+				http2Frame(http2FrameWindowUpdate, 0, 0, []byte{0, 0, 0, 100}),
+				http2Frame(http2FrameWindowUpdate, 0, 0, []byte{0, 0, 0, 200}), // only the first counts
+				http2Frame(http2FrameWindowUpdate, 0, 1, []byte{0, 0, 1, 44}),  // not for the connection
+				http2Frame(0xfa, 0, 0, []byte{1, 2, 3}),                        // unknown frame types are skipped
+				headers([]byte{0x82, 0x87, 0x41, 1, 'a', 0x84}),
+			},
+			akamai: "3:100;4:65535|100|0|m,s,a,p",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := []byte(http2Preface)
+			for _, frame := range test.frames {
+				data = append(data, frame...)
+			}
+			handshakes := assemble(tcpConnection([]segment{{true, data}}))
+			if len(handshakes) != 1 || handshakes[0].HTTP2 == nil {
+				t.Fatalf("got handshakes %+v, want one with an HTTP/2 fingerprint", handshakes)
+			}
+			if got := handshakes[0].HTTP2.Akamai; got != test.akamai {
+				t.Errorf("Akamai = %q, want %q", got, test.akamai)
+			}
+		})
+	}
+}
+
+// A client upgrading from HTTP/1.1 is reported once for its HTTP/1.1 request and once for the HTTP/2 connection.
+func TestHTTP2Upgrade(t *testing.T) {
+	request := []byte("GET / HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\n" +
+		"HTTP2-Settings: AAMAAABkAAQAAP__\r\n" +
+		"\r\n")
+
+	t.Run("accepted", func(t *testing.T) {
+		handshakes := assemble(tcpConnection([]segment{
+			{true, request},
+			{false, []byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")},
+			{true, chromeHTTP2Start()},
+		}))
+		if len(handshakes) != 2 {
+			t.Fatalf("got %d handshakes, want 2", len(handshakes))
+		}
+		if h := handshakes[0]; h.HTTP == nil || h.HTTP.Host != "example.com" || h.HTTP2 != nil {
+			t.Errorf("got %+v, want the HTTP/1.1 request", h)
+		}
+		if h := handshakes[1]; h.HTTP2 == nil || h.HTTP2.AkamaiHash != "52d84b11737d980aef856699f885ca86" || h.HTTP != nil {
+			t.Errorf("got %+v, want the HTTP/2 fingerprint", h)
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		handshakes := assemble(tcpConnection([]segment{
+			{true, request},
+			{false, []byte("HTTP/1.1 200 OK\r\n\r\n")},
+			{true, []byte("GET /again HTTP/1.1\r\nHost: example.com\r\n\r\n")},
+		}))
+		if len(handshakes) != 1 || handshakes[0].HTTP == nil || handshakes[0].HTTP2 != nil {
+			t.Errorf("got %+v, want only the HTTP/1.1 request", handshakes)
+		}
+	})
+}
+
+func TestIsH2CUpgrade(t *testing.T) {
+	tests := map[string]bool{
+		"h2c":            true,
+		"websocket, H2C": true,
+		"websocket":      false,
+		"h2":             false,
+		"":               false,
+	}
+	for upgrade, want := range tests {
+		if got := isH2CUpgrade(upgrade); got != want {
+			t.Errorf("isH2CUpgrade(%q) = %v, want %v", upgrade, got, want)
+		}
+	}
+}
+
+func TestHPACKPseudoHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		block []byte
+		want  []string
+	}{
+		{"Chrome", chromeHeaderBlock, []string{":method", ":authority", ":scheme", ":path"}},
+		{"literal names", []byte{0x00, 5, ':', 'p', 'a', 't', 'h', 1, '/', 0x10, 7, ':', 'm', 'e', 't', 'h', 'o', 'd', 3, 'G', 'E', 'T'}, []string{":path", ":method"}},
+		// A Huffman encoded name can't be read so nothing after it is either
+		{"Huffman encoded name", []byte{0x82, 0x00, 0x83, 0x01, 0x02, 0x03, 0x00, 0x84}, []string{":method"}},
+		{"regular header first", []byte{0x7a, 0, 0x82}, []string{}},
+		{"truncated", []byte{0x82, 0x41, 11, 'e', 'x'}, []string{":method"}},
+		{"empty", nil, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := hpackPseudoHeaders(test.block); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("hpackPseudoHeaders() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// RFC 7541, Appendix C.1
+func TestReadHPACKInteger(t *testing.T) {
+	tests := []struct {
+		data         []byte
+		prefixBits   int
+		value, bytes int
+	}{
+		{[]byte{0xea}, 5, 10, 1}, // the bits before the prefix are ignored
+		{[]byte{0x1f, 0x9a, 0x0a}, 5, 1337, 3},
+		{[]byte{0x2a}, 8, 42, 1},
+		{[]byte{0x1f, 0x9a}, 5, 0, 0},
+		{[]byte{0x1f, 0xff, 0xff, 0xff, 0xff, 0x0f}, 5, 0, 0},
+		{nil, 5, 0, 0},
+	}
+	for _, test := range tests {
+		if value, n := readHPACKInteger(test.data, test.prefixBits); value != test.value || n != test.bytes {
+			t.Errorf("readHPACKInteger(%x, %d) = %d, %d, want %d, %d", test.data, test.prefixBits, value, n, test.value, test.bytes)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package ja3assembler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// The expected fingerprints were calculated from the headers by an independent implementation.
+func TestHTTP(t *testing.T) {
+	request := "GET /index.html HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"User-Agent: curl/8.0\r\n" +
+		"  continued\r\n" +
+		"Accept: */*\r\n" +
+		"Accept-Language: en-US,en;q=0.9\r\n" +
+		"Cookie: b=2; a=1\r\n" +
+		"Referer: http://example.com/\r\n" +
+		"\r\n" +
+		"body"
+	// The request is split across segments, part way through the request line and a header
+	handshakes := assemble(tcpConnection([]segment{
+		{true, []byte(request[:5])},
+		{true, []byte(request[5:40])},
+		{true, []byte(request[40:])},
+		{false, []byte("HTTP/1.1 200 OK\r\n\r\n")},
+	}))
+	if len(handshakes) != 1 {
+		t.Fatalf("got %d handshakes, want 1", len(handshakes))
+	}
+
+	h := handshakes[0]
+	if h.SrcIP.String() != "10.0.0.1" || h.SrcPort != 50000 || h.DstIP.String() != "10.0.0.2" || h.DstPort != 443 {
+		t.Errorf("handshake from %s:%d to %s:%d, want from the client", h.SrcIP, h.SrcPort, h.DstIP, h.DstPort)
+	}
+	if h.HTTP == nil || h.HTTP2 != nil || h.JA3 != "" || h.Transport != TransportTCP {
+		t.Fatalf("unexpected handshake %+v", h)
+	}
+	fields := []struct{ name, got, want string }{
+		{"Method", h.HTTP.Method, "GET"},
+		{"Host", h.HTTP.Host, "example.com"},
+		{"UserAgent", h.HTTP.UserAgent, "curl/8.0 continued"},
+		{"JA4Hr", h.HTTP.JA4Hr, "ge11cr04enus_Host,User-Agent,Accept,Accept-Language_a,b_a=1,b=2"},
+		{"JA4H", h.HTTP.JA4H, "ge11cr04enus_8ddaef5d77af_1eb7c54d5283_06beefe2b477"},
+	}
+	for _, field := range fields {
+		if field.got != field.want {
+			t.Errorf("%s = %q, want %q", field.name, field.got, field.want)
+		}
+	}
+	if !h.Time.Equal(testStart.Add(5 * time.Millisecond)) {
+		t.Errorf("Time = %v, want when the end of the headers was sent", h.Time)
+	}
+}
+
+func TestHTTPNotRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+	}{
+		{"headers not finished", "GET / HTTP/1.1\r\nHost: example.com\r\n"},
+		{"not HTTP/1.x", "GET / HTTP/2.0\r\nHost: example.com\r\n\r\n"},
+		{"no version", "GET /\r\n\r\n"},
+		{"unknown method", "FETCH / HTTP/1.1\r\n\r\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if handshakes := assemble(tcpConnection([]segment{{true, []byte(test.request)}})); len(handshakes) != 0 {
+				t.Errorf("got handshakes %+v, want none", handshakes)
+			}
+		})
+	}
+}
+
+func TestCalculateJA4H(t *testing.T) {
+	manyHeaders := []httpHeader{}
+	for i := 0; i < 100; i++ {
+		manyHeaders = append(manyHeaders, httpHeader{fmt.Sprintf("X-%d", i), ""})
+	}
+	tests := []struct {
+		name    string
+		method  string
+		version string
+		headers []httpHeader
+		ja4h    string
+	}{
+		{"no headers", "POST", "HTTP/1.0", nil, "po10nn000000_000000000000_000000000000_000000000000"},
+		{"empty cookie", "GET", "HTTP/1.1", []httpHeader{{"Host", "example.com"}, {"Cookie", ""}}, "ge11cn010000_4a823118b9ba_000000000000_000000000000"},
+		{"too many headers", "GET", "HTTP/1.1", manyHeaders, "ge11nn990000_006476603784_000000000000_000000000000"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ja4h, _ := calculateJA4H(test.method, test.version, test.headers); ja4h != test.ja4h {
+				t.Errorf("calculateJA4H() = %q, want %q", ja4h, test.ja4h)
+			}
+		})
+	}
+}
+
+func TestJA4HLanguageCode(t *testing.T) {
+	tests := map[string]string{
+		"":                "0000",
+		"en-US,en;q=0.9":  "enus",
+		"fr;q=1":          "fr00",
+		" de-CH-1996, de": "dech",
+		"*":               "*000",
+	}
+	for acceptLanguage, want := range tests {
+		if got := ja4hLanguageCode(acceptLanguage); got != want {
+			t.Errorf("ja4hLanguageCode(%q) = %q, want %q", acceptLanguage, got, want)
+		}
+	}
+}
@@ -7,70 +7,86 @@ import (
 	"strings"
 )
 
-// GREASE values are random TLS extensions added to handshakes to highlight bad implementations
-// that reject unknown extensions and so are likely to fail when new real features are added.
+// isGREASE returns whether a value is GREASE: a random cipher suite, extension, curve etc. added to handshakes to
+// highlight bad implementations that reject unknown values and so are likely to fail when new real features are added.
 // These get added randomly per-handshake by supporting clients which unless ignored confuses the fingerprint.
-// More info: https://tools.ietf.org/html/draft-ietf-tls-grease-01
-var greaseTable = map[uint16]bool{
-	0x0a0a: true, 0x1a1a: true, 0x2a2a: true, 0x3a3a: true,
-	0x4a4a: true, 0x5a5a: true, 0x6a6a: true, 0x7a7a: true,
-	0x8a8a: true, 0x9a9a: true, 0xaaaa: true, 0xbaba: true,
-	0xcaca: true, 0xdada: true, 0xeaea: true, 0xfafa: true,
+// RFC 8701 only reserves the 0x?a?a values with both bytes the same but every value of that form is ignored,
+// which also covers any implementation that strays from the reserved list, and no real value in use has that form.
+// More info: https://tools.ietf.org/html/rfc8701
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a
 }
 
 // Adapted from https://github.com/honeytrap/honeytrap/blob/add50606512b3e6ad5f3951e5a110faef42bbda1/services/ja3/crypto/tls/common.go#L292
-func calculateJA3(c *clientHelloMsg) string {
+// calculateJA3 returns both the JA3 hash and the string it was calculated from.
+func calculateJA3(c *clientHelloMsg) (ja3, ja3String string) {
 	// JA3 = SSLVersion,Cipher,SSLExtension,EllipticCurve,EllipticCurvePointFormat
-	hasher := md5.New()
-	hasher.Write([]byte(fmt.Sprintf("%d,", c.vers)))
+	fields := []string{fmt.Sprintf("%d", c.vers)}
 
 	vals := []string{}
 	for _, v := range c.cipherSuites {
+		if isGREASE(v) {
+			continue
+		}
+
 		vals = append(vals, fmt.Sprintf("%d", v))
 	}
-	hasher.Write([]byte(fmt.Sprintf("%s,", strings.Join(vals, "-"))))
+	fields = append(fields, strings.Join(vals, "-"))
 
 	vals = []string{}
 	for _, v := range c.extensions {
-		if _, ok := greaseTable[v]; ok {
+		if isGREASE(v) {
 			continue
 		}
 
 		vals = append(vals, fmt.Sprintf("%d", v))
 	}
-
-	hasher.Write([]byte(fmt.Sprintf("%s,", strings.Join(vals, "-"))))
+	fields = append(fields, strings.Join(vals, "-"))
 
 	vals = []string{}
 	for _, v := range c.supportedCurves {
+		if isGREASE(uint16(v)) {
+			continue
+		}
+
 		vals = append(vals, fmt.Sprintf("%d", v))
 	}
-	hasher.Write([]byte(fmt.Sprintf("%s,", strings.Join(vals, "-"))))
+	fields = append(fields, strings.Join(vals, "-"))
 
 	vals = []string{}
 	for _, v := range c.supportedPoints {
 		vals = append(vals, fmt.Sprintf("%d", v))
 	}
-	hasher.Write([]byte(fmt.Sprintf("%s", strings.Join(vals, "-"))))
+	fields = append(fields, strings.Join(vals, "-"))
 
-	return hex.EncodeToString(hasher.Sum(nil))
+	ja3String = strings.Join(fields, ",")
+	return md5Hex(ja3String), ja3String
 }
 
-func calculateJA3S(s *serverHelloMsg) string {
+// calculateJA3S returns both the JA3S hash and the string it was calculated from.
+func calculateJA3S(s *serverHelloMsg) (ja3s, ja3sString string) {
 	// JA3S = SSLVersion,Cipher,SSLExtension
-	hasher := md5.New()
-	hasher.Write([]byte(fmt.Sprintf("%d,", s.vers)))
-	hasher.Write([]byte(fmt.Sprintf("%d,", s.cipherSuite)))
+	fields := []string{fmt.Sprintf("%d", s.vers), ""}
+	if !isGREASE(s.cipherSuite) {
+		// A server should never choose a GREASE cipher suite but if it does it's not part of the fingerprint
+		fields[1] = fmt.Sprintf("%d", s.cipherSuite)
+	}
 
 	vals := []string{}
 	for _, v := range s.extensions {
-		if _, ok := greaseTable[v]; ok {
+		if isGREASE(v) {
 			continue
 		}
 
 		vals = append(vals, fmt.Sprintf("%d", v))
 	}
-	hasher.Write([]byte(strings.Join(vals, "-")))
+	fields = append(fields, strings.Join(vals, "-"))
+
+	ja3sString = strings.Join(fields, ",")
+	return md5Hex(ja3sString), ja3sString
+}
 
-	return hex.EncodeToString(hasher.Sum(nil))
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,128 @@
+package ja3assembler
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readHex reads a hex dump from the testdata directory.
+func readHex(t *testing.T, file string) []byte {
+	dump, err := ioutil.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := hex.DecodeString(strings.Join(strings.Fields(string(dump)), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readClientHello parses a ClientHello record from a hex dump in testdata.
+func readClientHello(t *testing.T, file string) *clientHelloMsg {
+	record := readHex(t, file)
+	msg := &clientHelloMsg{}
+	if len(record) < recordHeaderLength || !msg.unmarshal(record[recordHeaderLength:]) {
+		t.Fatalf("%s isn't a ClientHello record", file)
+	}
+	return msg
+}
+
+// The ClientHellos were sent by real clients. Their JA3 values were checked against an independent implementation.
+func TestJA3(t *testing.T) {
+	tests := []struct {
+		file      string
+		ja3String string
+		ja3       string
+	}{
+		{
+			// curl 7.88.1 with OpenSSL 3.0.17, from curl-tls13.pcap
+			file:      "clienthello-curl.hex",
+			ja3String: "771,4866-4867-4865-255,0-11-10-16-22-23-49-13-43-45-51-21,29-23-30-25-24-256-257-258-259-260,0-1-2",
+			ja3:       "89dfc51e72bdcb94e4ba4622588002b1",
+		},
+		{
+			// openssl s_client, from Go's crypto/tls/testdata/Server-TLSv13-AES128-SHA256
+			file:      "clienthello-openssl.hex",
+			ja3String: "771,4865-255,0-11-10-22-23-13-43-45-51,29-23-30-25-24,0-1-2",
+			ja3:       "10e3d6a14d30074ea3c9aeac8c9c80be",
+		},
+		{
+			// TLS 1.0 client from https://github.com/tintinweb/scapy-ssl_tls/blob/master/tests/files/RSA_WITH_AES_128_CBC_SHA.pcap
+			file:      "clienthello-scapy-ssl_tls.hex",
+			ja3String: "769,49172-49162-57-56-136-135-49167-49157-53-132-49171-49161-51-50-154-153-69-68-49166-49156-47-150-65-49169-49159-49164-49154-5-4-49170-49160-22-19-49165-49155-10-21-18-9-20-17-8-6-3-255,11-10-35-15,14-13-25-11-12-24-9-10-22-23-8-6-7-20-21-4-5-18-19-1-2-3-15-16-17,0-1-2",
+			ja3:       "2648a3430cfab8cd9cfe83dd572bee30",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			ja3, ja3String := calculateJA3(readClientHello(t, test.file))
+			if ja3String != test.ja3String {
+				t.Errorf("got JA3 string %s, want %s", ja3String, test.ja3String)
+			}
+			if ja3 != test.ja3 {
+				t.Errorf("got JA3 %s, want %s", ja3, test.ja3)
+			}
+		})
+	}
+}
+
+func TestJA3Published(t *testing.T) {
+	// The example from https://github.com/salesforce/ja3
+	msg := &clientHelloMsg{
+		vers:            769,
+		cipherSuites:    []uint16{47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+		extensions:      []uint16{0, 10, 11},
+		supportedCurves: []tls.CurveID{23, 24, 25},
+		supportedPoints: []uint8{0},
+	}
+	ja3, ja3String := calculateJA3(msg)
+	if want := "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0"; ja3String != want {
+		t.Errorf("got JA3 string %s, want %s", ja3String, want)
+	}
+	if want := "ada70206e40642a3e4461f35503241d5"; ja3 != want {
+		t.Errorf("got JA3 %s, want %s", ja3, want)
+	}
+}
+
+func TestJA3GREASE(t *testing.T) {
+	msg := readClientHello(t, "clienthello-curl.hex")
+	wantJA3, _ := calculateJA3(msg)
+	wantJA4, _ := calculateJA4(msg, TransportTCP)
+
+	// As a client like Chrome would, add GREASE values to the start and end of each list
+	greased := *msg
+	greased.cipherSuites = append(append([]uint16{0x1a1a}, msg.cipherSuites...), 0xfafa)
+	greased.extensions = append(append([]uint16{0x2a2a}, msg.extensions...), 0x3a3a)
+	greased.supportedCurves = append([]tls.CurveID{0x4a4a}, msg.supportedCurves...)
+	greased.supportedVersions = append([]uint16{0x5a5a}, msg.supportedVersions...)
+	greased.supportedSignatureAlgorithms = append([]tls.SignatureScheme{0x6a6a}, msg.supportedSignatureAlgorithms...)
+	if ja3, ja3String := calculateJA3(&greased); ja3 != wantJA3 {
+		t.Errorf("GREASE changed the JA3 to %s (%s)", ja3, ja3String)
+	}
+	if ja4, ja4r := calculateJA4(&greased, TransportTCP); ja4 != wantJA4 {
+		t.Errorf("GREASE changed the JA4 to %s (%s)", ja4, ja4r)
+	}
+
+	serverHello := &serverHelloMsg{vers: 771, cipherSuite: 4865, extensions: []uint16{43, 0x7a7a, 51}}
+	if _, ja3sString := calculateJA3S(serverHello); ja3sString != "771,4865,43-51" {
+		t.Errorf("got JA3S string %s, want the GREASE extension left out", ja3sString)
+	}
+}
+
+func TestIsGREASE(t *testing.T) {
+	for _, v := range []uint16{0x0a0a, 0x1a1a, 0x2a2a, 0x3a3a, 0x4a4a, 0x5a5a, 0x6a6a, 0x7a7a, 0x8a8a, 0x9a9a, 0xaaaa, 0xbaba, 0xcaca, 0xdada, 0xeaea, 0xfafa, 0x1a2a} {
+		if !isGREASE(v) {
+			t.Errorf("%#04x should be GREASE", v)
+		}
+	}
+	for _, v := range []uint16{0x000a, 0x0a00, 0x0a0b, 0x1301, 0xc02b, 0xff01} {
+		if isGREASE(v) {
+			t.Errorf("%#04x shouldn't be GREASE", v)
+		}
+	}
+}
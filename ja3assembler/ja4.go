@@ -0,0 +1,338 @@
+package ja3assembler
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// JA4 truncates each of its hashed sections to this many hex characters.
+const ja4HashLength = 12
+
+// tlsVersionCodes maps TLS (and DTLS) version numbers to the two character codes used by JA4.
+var tlsVersionCodes = map[uint16]string{
+	0x0304: "13",
+	0x0303: "12",
+	0x0302: "11",
+	0x0301: "10",
+	0x0300: "s3",
+	0x0002: "s2",
+	0xfeff: "d1",
+	0xfefd: "d2",
+	0xfefc: "d3",
+}
+
+// ja4TransportCodes maps the transport a handshake was carried over to the protocol character JA4 starts with.
+var ja4TransportCodes = map[string]string{
+	TransportTCP:  "t",
+	TransportQUIC: "q",
+	TransportDTLS: "d",
+}
+
+// calculateJA4 returns both the JA4 fingerprint and its unhashed JA4_r form.
+// Spec: https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4.md
+func calculateJA4(c *clientHelloMsg, transport string) (ja4, ja4r string) {
+	// JA4 = ProtocolVersionSNICiphersExtensionsALPN_SortedCiphers_SortedExtensions_SignatureAlgorithms
+	version := c.vers
+	for _, v := range c.supportedVersions {
+		if isGREASE(v) {
+			continue
+		}
+		if isNewerVersion(v, version) {
+			version = v
+		}
+	}
+
+	sni := "i"
+	if c.serverName != "" {
+		sni = "d"
+	}
+
+	alpn := ""
+	if len(c.alpnProtocols) > 0 {
+		alpn = c.alpnProtocols[0]
+	}
+
+	ciphers := []string{}
+	for _, v := range c.cipherSuites {
+		if isGREASE(v) {
+			continue
+		}
+		ciphers = append(ciphers, fmt.Sprintf("%04x", v))
+	}
+	sort.Strings(ciphers)
+
+	// The extension count includes SNI and ALPN but the extension hash does not
+	extensionCount := 0
+	extensions := []string{}
+	for _, v := range c.extensions {
+		if isGREASE(v) {
+			continue
+		}
+		extensionCount++
+		if v == extensionServerName || v == extensionALPN {
+			continue
+		}
+		extensions = append(extensions, fmt.Sprintf("%04x", v))
+	}
+	sort.Strings(extensions)
+
+	signatureAlgorithms := []string{}
+	for _, v := range c.supportedSignatureAlgorithms {
+		if isGREASE(uint16(v)) {
+			continue
+		}
+		signatureAlgorithms = append(signatureAlgorithms, fmt.Sprintf("%04x", uint16(v)))
+	}
+
+	prefix := fmt.Sprintf("%s%s%s%02d%02d%s",
+		ja4TransportCodes[transport], tlsVersionCode(version), sni, min99(len(ciphers)), min99(extensionCount), ja4ALPNCode(alpn))
+
+	extensionsString := strings.Join(extensions, ",")
+	if len(signatureAlgorithms) > 0 {
+		extensionsString += "_" + strings.Join(signatureAlgorithms, ",")
+	}
+
+	ja4 = fmt.Sprintf("%s_%s_%s", prefix, ja4Hash(strings.Join(ciphers, ",")), ja4Hash(extensionsString))
+	ja4r = fmt.Sprintf("%s_%s_%s", prefix, strings.Join(ciphers, ","), extensionsString)
+	return ja4, ja4r
+}
+
+// isNewerVersion returns whether TLS or DTLS version a is newer than b. DTLS version numbers count down.
+func isNewerVersion(a, b uint16) bool {
+	if isDTLSVersion(a) && isDTLSVersion(b) {
+		return a < b
+	}
+	return a > b
+}
+
+func tlsVersionCode(version uint16) string {
+	if code, ok := tlsVersionCodes[version]; ok {
+		return code
+	}
+	return "00"
+}
+
+// ja4ALPNCode returns the first and last characters of an ALPN value, falling back to the
+// first and last hex characters if either end isn't alphanumeric.
+func ja4ALPNCode(alpn string) string {
+	if alpn == "" {
+		return "00"
+	}
+	first, last := alpn[0], alpn[len(alpn)-1]
+	if isAlphanumeric(first) && isAlphanumeric(last) {
+		return string([]byte{first, last})
+	}
+	firstHex, lastHex := fmt.Sprintf("%02x", first), fmt.Sprintf("%02x", last)
+	return firstHex[:1] + lastHex[1:]
+}
+
+func isAlphanumeric(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9')
+}
+
+// ja4Hash returns the truncated SHA256 of a JA4 section, or all zeros if the section is empty
+func ja4Hash(s string) string {
+	if s == "" {
+		return strings.Repeat("0", ja4HashLength)
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:ja4HashLength]
+}
+
+func min99(n int) int {
+	if n > 99 {
+		return 99
+	}
+	return n
+}
+
+// calculateJA4S returns the JA4S fingerprint of a ServerHello.
+// Spec: https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4S.md
+func calculateJA4S(s *serverHelloMsg, transport string) string {
+	// JA4S = ProtocolVersionExtensionsALPN_Cipher_Extensions
+	version := s.vers
+	if s.supportedVersion != 0 {
+		version = s.supportedVersion
+	}
+
+	// Unlike JA4, the server's extensions are hashed in the order they were sent
+	extensions := []string{}
+	for _, v := range s.extensions {
+		if isGREASE(v) {
+			continue
+		}
+		extensions = append(extensions, fmt.Sprintf("%04x", v))
+	}
+
+	return fmt.Sprintf("%s%s%02d%s_%04x_%s",
+		ja4TransportCodes[transport], tlsVersionCode(version), min99(len(extensions)), ja4ALPNCode(s.alpnProtocol),
+		s.cipherSuite, ja4Hash(strings.Join(extensions, ",")))
+}
+
+// calculateJA4X returns the JA4X fingerprint of a DER encoded certificate, which identifies how it was generated
+// rather than its contents. Only the structure of the TBSCertificate is needed so this works even for certificates
+// that crypto/x509 refuses to parse. It returns "" if the TBSCertificate itself is malformed.
+// Spec: https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4X.md
+func calculateJA4X(der []byte) string {
+	// JA4X = IssuerRDNs_SubjectRDNs_Extensions
+	input := cryptobyte.String(der)
+	var certificate, tbs cryptobyte.String
+	var issuer, subject cryptobyte.String
+	if !input.ReadASN1(&certificate, cryptobyte_asn1.SEQUENCE) ||
+		!certificate.ReadASN1(&tbs, cryptobyte_asn1.SEQUENCE) ||
+		!tbs.SkipOptionalASN1(cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) || // version
+		!tbs.SkipASN1(cryptobyte_asn1.INTEGER) || // serialNumber
+		!tbs.SkipASN1(cryptobyte_asn1.SEQUENCE) || // signature
+		!tbs.ReadASN1Element(&issuer, cryptobyte_asn1.SEQUENCE) ||
+		!tbs.SkipASN1(cryptobyte_asn1.SEQUENCE) || // validity
+		!tbs.ReadASN1Element(&subject, cryptobyte_asn1.SEQUENCE) ||
+		!tbs.SkipASN1(cryptobyte_asn1.SEQUENCE) || // subjectPublicKeyInfo
+		!tbs.SkipOptionalASN1(cryptobyte_asn1.Tag(1).ContextSpecific()) || // issuerUniqueID
+		!tbs.SkipOptionalASN1(cryptobyte_asn1.Tag(2).ContextSpecific()) { // subjectUniqueID
+		return ""
+	}
+
+	// extensions [3] EXPLICIT SEQUENCE OF Extension, where Extension ::= SEQUENCE { extnID OBJECT IDENTIFIER, ... }
+	extensions := []string{}
+	var wrapper, extensionSequence cryptobyte.String
+	if tbs.ReadASN1(&wrapper, cryptobyte_asn1.Tag(3).Constructed().ContextSpecific()) &&
+		wrapper.ReadASN1(&extensionSequence, cryptobyte_asn1.SEQUENCE) {
+		for !extensionSequence.Empty() {
+			var extension, oid cryptobyte.String
+			if !extensionSequence.ReadASN1(&extension, cryptobyte_asn1.SEQUENCE) ||
+				!extension.ReadASN1Element(&oid, cryptobyte_asn1.OBJECT_IDENTIFIER) {
+				break
+			}
+			extensions = append(extensions, oidHex(oid))
+		}
+	}
+
+	return fmt.Sprintf("%s_%s_%s",
+		ja4Hash(strings.Join(rdnOIDs(issuer), ",")),
+		ja4Hash(strings.Join(rdnOIDs(subject), ",")),
+		ja4Hash(strings.Join(extensions, ",")))
+}
+
+// rdnOIDs returns the hex encoded attribute type OIDs of each RDN in a DER encoded name, in order.
+func rdnOIDs(name []byte) []string {
+	oids := []string{}
+	s := cryptobyte.String(name)
+	var rdnSequence cryptobyte.String
+	if !s.ReadASN1(&rdnSequence, cryptobyte_asn1.SEQUENCE) {
+		return oids
+	}
+	for !rdnSequence.Empty() {
+		var rdnSet cryptobyte.String
+		if !rdnSequence.ReadASN1(&rdnSet, cryptobyte_asn1.SET) {
+			return oids
+		}
+		for !rdnSet.Empty() {
+			var attribute, oid cryptobyte.String
+			if !rdnSet.ReadASN1(&attribute, cryptobyte_asn1.SEQUENCE) ||
+				!attribute.ReadASN1Element(&oid, cryptobyte_asn1.OBJECT_IDENTIFIER) {
+				return oids
+			}
+			oids = append(oids, oidHex(oid))
+		}
+	}
+	return oids
+}
+
+// oidHex returns the hex of the content bytes of a DER encoded OID, e.g. 550403 for commonName.
+func oidHex(der []byte) string {
+	s := cryptobyte.String(der)
+	var content cryptobyte.String
+	if !s.ReadASN1(&content, cryptobyte_asn1.OBJECT_IDENTIFIER) {
+		return ""
+	}
+	return hex.EncodeToString(content)
+}
+
+// calculateJA4T returns the JA4T fingerprint of a SYN, or the JA4TS fingerprint of a SYN-ACK, which identify
+// the TCP stack that sent them.
+// Spec: https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4T.md
+func calculateJA4T(tcp *layers.TCP) string {
+	// JA4T = WindowSize_Options_MSS_WindowScale
+	options := []string{}
+	mss, windowScale := "00", "00"
+	for _, option := range tcp.Options {
+		options = append(options, fmt.Sprint(uint8(option.OptionType)))
+		switch {
+		case option.OptionType == layers.TCPOptionKindMSS && len(option.OptionData) == 2:
+			mss = fmt.Sprint(binary.BigEndian.Uint16(option.OptionData))
+		case option.OptionType == layers.TCPOptionKindWindowScale && len(option.OptionData) == 1:
+			windowScale = fmt.Sprint(option.OptionData[0])
+		}
+	}
+	if len(options) == 0 {
+		options = append(options, "00")
+	}
+	return fmt.Sprintf("%d_%s_%s_%s", tcp.Window, strings.Join(options, "-"), mss, windowScale)
+}
+
+// calculateJA4L returns the JA4L-C and JA4L-S fingerprints of a connection's latency to the client and server,
+// which estimate how far away each is. JA4L-C is empty if the ACK completing the TCP handshake wasn't seen.
+// Spec: https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4L.md
+func calculateJA4L(h Handshake) (client, server string) {
+	// JA4L = OneWayLatencyMicroseconds_TTL
+	if h.SYNACKToACK > 0 {
+		client = fmt.Sprintf("%d_%d", (h.SYNACKToACK / 2).Microseconds(), h.ClientTTL)
+	}
+	server = fmt.Sprintf("%d_%d", (h.SYNToSYNACK / 2).Microseconds(), h.ServerTTL)
+	return client, server
+}
+
+// calculateJA4H returns both the JA4H fingerprint of an HTTP request and its unhashed JA4H_r form.
+// Spec: https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4H.md
+func calculateJA4H(method, version string, headers []httpHeader) (ja4h, ja4hr string) {
+	// JA4H = MethodVersionCookieRefererHeaderCountLanguage_Headers_CookieNames_Cookies
+	names, cookieNames, cookies := []string{}, []string{}, []string{}
+	cookie, referer, language := "n", "n", "0000"
+	for _, header := range headers {
+		switch strings.ToLower(header.name) {
+		case "cookie":
+			cookie = "c"
+			for _, c := range strings.Split(header.value, ";") {
+				if c = strings.TrimSpace(c); c != "" {
+					cookies = append(cookies, c)
+					cookieNames = append(cookieNames, strings.SplitN(c, "=", 2)[0])
+				}
+			}
+			// Cookie and Referer aren't counted as headers
+			continue
+		case "referer":
+			referer = "r"
+			continue
+		case "accept-language":
+			language = ja4hLanguageCode(header.value)
+		}
+		names = append(names, header.name)
+	}
+	sort.Strings(cookieNames)
+	sort.Strings(cookies)
+
+	versionCode := strings.Replace(strings.TrimPrefix(version, "HTTP/"), ".", "", -1)
+	a := fmt.Sprintf("%s%s%s%s%02d%s",
+		strings.ToLower(method[:2]), versionCode, cookie, referer, min99(len(names)), language)
+	ja4h = fmt.Sprintf("%s_%s_%s_%s", a,
+		ja4Hash(strings.Join(names, ",")), ja4Hash(strings.Join(cookieNames, ",")), ja4Hash(strings.Join(cookies, ",")))
+	ja4hr = fmt.Sprintf("%s_%s_%s_%s", a,
+		strings.Join(names, ","), strings.Join(cookieNames, ","), strings.Join(cookies, ","))
+	return ja4h, ja4hr
+}
+
+// ja4hLanguageCode returns the first four letters of the primary language in an Accept-Language header, e.g. enus for en-US.
+func ja4hLanguageCode(acceptLanguage string) string {
+	language := strings.ToLower(strings.Replace(acceptLanguage, "-", "", -1))
+	language = strings.Split(strings.Replace(language, ";", ",", -1), ",")[0]
+	language = strings.TrimSpace(language) + "0000"
+	return language[:4]
+}
@@ -0,0 +1,281 @@
+package ja3assembler
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/hkdf"
+)
+
+// quicVersion holds what differs between the QUIC versions whose Initial packets can be decrypted.
+type quicVersion struct {
+	// salt used to derive the Initial secrets from the client's destination connection ID
+	salt []byte
+	// labelPrefix is prepended to the labels used to derive the packet protection keys
+	labelPrefix string
+	// initialType is the long header packet type of Initial packets
+	initialType byte
+}
+
+var (
+	// RFC 9001, Section 5.2
+	quicVersion1 = quicVersion{
+		salt:        []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a},
+		labelPrefix: "quic",
+		initialType: 0,
+	}
+	// draft-ietf-quic-tls-29, Section 5.2. This salt was also used by drafts 30 to 32.
+	quicDraft29 = quicVersion{
+		salt:        []byte{0xaf, 0xbf, 0xec, 0x28, 0x99, 0x93, 0xd2, 0x4c, 0x9e, 0x97, 0x86, 0xf1, 0x9c, 0x61, 0x11, 0xe0, 0x43, 0x90, 0xa8, 0x99},
+		labelPrefix: "quic",
+		initialType: 0,
+	}
+	// RFC 9369, Section 3.3
+	quicVersion2 = quicVersion{
+		salt:        []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9},
+		labelPrefix: "quicv2",
+		initialType: 1,
+	}
+)
+
+// quicVersions maps the version numbers in QUIC long headers to how to decrypt their Initial packets.
+var quicVersions = map[uint32]quicVersion{
+	0x00000001: quicVersion1,
+	0x6b3343cf: quicVersion2,
+	0xff00001d: quicDraft29,
+	0xff00001e: quicDraft29,
+	0xff00001f: quicDraft29,
+	0xff000020: quicDraft29,
+}
+
+// QUIC frame types which may appear in Initial packets (RFC 9000, Section 12.4)
+const (
+	quicFramePadding         = 0x00
+	quicFramePing            = 0x01
+	quicFrameACK             = 0x02
+	quicFrameACKECN          = 0x03
+	quicFrameCrypto          = 0x06
+	quicFrameConnectionClose = 0x1c
+)
+
+// quicPacket is a QUIC long header packet which still has its packet protection applied.
+type quicPacket struct {
+	version    uint32
+	packetType byte
+	dcid, scid []byte
+	// raw is the whole packet and pnOffset is where its (protected) packet number starts.
+	// The packet number and payload take up the rest of the packet.
+	raw      []byte
+	pnOffset int
+}
+
+// parseQUICPacket parses the first long header packet in a UDP datagram, returning it and the rest of the datagram.
+// Several packets can be coalesced into one datagram but only long header packets have a length so ok is false
+// once a short header packet (or something that isn't QUIC) is reached.
+func parseQUICPacket(datagram []byte) (packet quicPacket, rest []byte, ok bool) {
+	s := cryptobyte.String(datagram)
+	var firstByte uint8
+	if !s.ReadUint8(&firstByte) || firstByte&0xc0 != 0xc0 {
+		// Not a long header packet with the fixed bit set
+		return quicPacket{}, nil, false
+	}
+	packet.packetType = firstByte >> 4 & 0x03
+
+	var dcid, scid cryptobyte.String
+	if !s.ReadUint32(&packet.version) ||
+		!s.ReadUint8LengthPrefixed(&dcid) ||
+		!s.ReadUint8LengthPrefixed(&scid) {
+		return quicPacket{}, nil, false
+	}
+	packet.dcid, packet.scid = dcid, scid
+
+	version, ok := quicVersions[packet.version]
+	if !ok || packet.packetType != version.initialType {
+		// Only Initial packets in known versions have a known format and can be decrypted
+		return quicPacket{}, nil, false
+	}
+
+	var tokenLength, length uint64
+	if !readQUICVarint(&s, &tokenLength) || !skipQUICBytes(&s, tokenLength) || !readQUICVarint(&s, &length) || uint64(len(s)) < length {
+		return quicPacket{}, nil, false
+	}
+	packet.pnOffset = len(datagram) - len(s)
+	packet.raw = datagram[:packet.pnOffset+int(length)]
+	return packet, datagram[len(packet.raw):], true
+}
+
+// readQUICVarint reads a variable length integer (RFC 9000, Section 16).
+func readQUICVarint(s *cryptobyte.String, out *uint64) bool {
+	var first uint8
+	if !s.ReadUint8(&first) {
+		return false
+	}
+	// The top two bits give the length as a power of two
+	length := 1<<(first>>6) - 1
+	value := uint64(first & 0x3f)
+	var rest []byte
+	if !s.ReadBytes(&rest, length) {
+		return false
+	}
+	for _, b := range rest {
+		value = value<<8 | uint64(b)
+	}
+	*out = value
+	return true
+}
+
+// skipQUICBytes skips over length bytes, where length was read from a varint so may be too large to be an int.
+func skipQUICBytes(s *cryptobyte.String, length uint64) bool {
+	return uint64(len(*s)) >= length && s.Skip(int(length))
+}
+
+// quicKeys are the keys protecting one direction of a connection's Initial packets.
+type quicKeys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   cipher.Block // header protection
+}
+
+// newQUICInitialKeys derives the keys protecting the Initial packets sent by the client or server.
+// Spec: https://www.rfc-editor.org/rfc/rfc9001#section-5.2
+func newQUICInitialKeys(version quicVersion, dcid []byte, server bool) (*quicKeys, error) {
+	initialSecret := hkdf.Extract(sha256.New, dcid, version.salt)
+	label := "client in"
+	if server {
+		label = "server in"
+	}
+	secret := hkdfExpandLabel(initialSecret, label, sha256.Size)
+
+	block, err := aes.NewCipher(hkdfExpandLabel(secret, version.labelPrefix+" key", 16))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(hkdfExpandLabel(secret, version.labelPrefix+" hp", 16))
+	if err != nil {
+		return nil, err
+	}
+	return &quicKeys{
+		aead: aead,
+		iv:   hkdfExpandLabel(secret, version.labelPrefix+" iv", aead.NonceSize()),
+		hp:   hp,
+	}, nil
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label from TLS 1.3 (RFC 8446, Section 7.1) with an empty context.
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	var info cryptobyte.Builder
+	info.AddUint16(uint16(length))
+	info.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte("tls13 " + label))
+	})
+	info.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {})
+
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, secret, info.BytesOrPanic()), out); err != nil {
+		panic("hkdf: " + err.Error())
+	}
+	return out
+}
+
+// decrypt removes the header protection from a packet and decrypts its payload.
+// Spec: https://www.rfc-editor.org/rfc/rfc9001#section-5.4
+func (k *quicKeys) decrypt(packet quicPacket) ([]byte, bool) {
+	// The header protection mask is made from a sample of the payload, taken as if the packet number were 4 bytes long
+	const sampleOffset, sampleLength = 4, 16
+	if len(packet.raw) < packet.pnOffset+sampleOffset+sampleLength {
+		return nil, false
+	}
+	mask := make([]byte, k.hp.BlockSize())
+	k.hp.Encrypt(mask, packet.raw[packet.pnOffset+sampleOffset:packet.pnOffset+sampleOffset+sampleLength])
+
+	header := append([]byte{}, packet.raw[:packet.pnOffset+4]...)
+	header[0] ^= mask[0] & 0x0f
+	pnLength := int(header[0]&0x03) + 1
+	header = header[:packet.pnOffset+pnLength]
+
+	// Initial packets are numbered from zero so, unlike in general, the truncated packet number is the full packet number
+	var pn uint64
+	for i := 0; i < pnLength; i++ {
+		header[packet.pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(header[packet.pnOffset+i])
+	}
+
+	nonce := append([]byte{}, k.iv...)
+	var pnBytes [8]byte
+	binary.BigEndian.PutUint64(pnBytes[:], pn)
+	for i, b := range pnBytes {
+		nonce[len(nonce)-len(pnBytes)+i] ^= b
+	}
+
+	payload, err := k.aead.Open(nil, nonce, packet.raw[len(header):], header)
+	return payload, err == nil
+}
+
+// quicCryptoFrame is the part of the TLS handshake carried in a CRYPTO frame.
+type quicCryptoFrame struct {
+	offset uint64
+	data   []byte
+}
+
+// readQUICCryptoFrames returns the CRYPTO frames in the decrypted payload of an Initial packet.
+func readQUICCryptoFrames(payload []byte) []quicCryptoFrame {
+	frames := []quicCryptoFrame{}
+	s := cryptobyte.String(payload)
+	for !s.Empty() {
+		var frameType uint64
+		if !readQUICVarint(&s, &frameType) {
+			return frames
+		}
+
+		switch frameType {
+		case quicFramePadding, quicFramePing:
+			// No content
+
+		case quicFrameACK, quicFrameACKECN:
+			var largest, delay, rangeCount, firstRange uint64
+			if !readQUICVarint(&s, &largest) || !readQUICVarint(&s, &delay) ||
+				!readQUICVarint(&s, &rangeCount) || !readQUICVarint(&s, &firstRange) {
+				return frames
+			}
+			// Each range is a gap and a length, followed by three ECN counts if this is an ACK_ECN frame
+			fields := 2 * rangeCount
+			if frameType == quicFrameACKECN {
+				fields += 3
+			}
+			for i := uint64(0); i < fields; i++ {
+				var field uint64
+				if !readQUICVarint(&s, &field) {
+					return frames
+				}
+			}
+
+		case quicFrameCrypto:
+			var offset, length uint64
+			if !readQUICVarint(&s, &offset) || !readQUICVarint(&s, &length) || uint64(len(s)) < length {
+				return frames
+			}
+			frames = append(frames, quicCryptoFrame{offset, s[:length]})
+			s = s[length:]
+
+		case quicFrameConnectionClose:
+			var errorCode, triggeringFrame, reasonLength uint64
+			if !readQUICVarint(&s, &errorCode) || !readQUICVarint(&s, &triggeringFrame) ||
+				!readQUICVarint(&s, &reasonLength) || !skipQUICBytes(&s, reasonLength) {
+				return frames
+			}
+
+		default:
+			// No other frames are allowed in Initial packets
+			return frames
+		}
+	}
+	return frames
+}
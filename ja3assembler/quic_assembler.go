@@ -0,0 +1,236 @@
+package ja3assembler
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+const (
+	// quicTimeout is how long to wait for the rest of a QUIC handshake after the last Initial packet
+	// before giving up and reporting whatever has been seen so far.
+	quicTimeout = 30 * time.Second
+	// maxQUICConnections limits how many QUIC connections are tracked at once
+	maxQUICConnections = 10000
+	// maxQUICCryptoLength limits how much CRYPTO frame data is buffered for each direction of a connection.
+	// ClientHellos don't normally need more than a couple of packets.
+	maxQUICCryptoLength = 64 << 10
+)
+
+// quicAssembler recovers the ClientHello and ServerHello from QUIC connections' Initial packets,
+// which are encrypted with keys derived from the client's (public) destination connection ID.
+type quicAssembler struct {
+	udpConnections
+}
+
+// quicConnection tracks the handshake of a single QUIC connection.
+type quicConnection struct {
+	udpHandshake
+	dcid []byte // the client's original destination connection ID, from which the keys are derived
+
+	clientKeys, serverKeys     *quicKeys
+	clientCrypto, serverCrypto quicCryptoStream
+}
+
+// quicCryptoStream reassembles the TLS handshake messages carried in CRYPTO frames, which may arrive out of order.
+type quicCryptoStream struct {
+	data    []byte            // contiguous data from the start of the stream
+	pending map[uint64][]byte // data received ahead of the contiguous data, by offset
+	length  int               // total buffered
+	read    int               // offset in data of the first message not yet returned by nextMessage
+}
+
+func newQUICAssembler(callback func(Handshake)) *quicAssembler {
+	return &quicAssembler{newUDPConnections(callback, quicTimeout, maxQUICConnections)}
+}
+
+// assemble handles a UDP datagram which may contain QUIC packets.
+func (a *quicAssembler) assemble(netFlow, udpFlow gopacket.Flow, datagram []byte, timestamp time.Time) {
+	k := key{netFlow, udpFlow}
+	for {
+		packet, rest, ok := parseQUICPacket(datagram)
+		if !ok {
+			break
+		}
+		datagram = rest
+
+		conn, _ := a.connections[k].(*quicConnection)
+		if conn == nil {
+			// Only a client can start a connection, and the first packet it sends is an Initial with the start of its ClientHello.
+			// Anything else is either not QUIC, or a connection whose start was missed so can't be fingerprinted.
+			if a.full() {
+				break
+			}
+			conn = &quicConnection{udpHandshake: udpHandshake{client: k}}
+			if !conn.setDCID(packet) || !conn.startsClientHello(packet) {
+				break
+			}
+			a.track(netFlow, udpFlow, conn)
+		}
+		if conn.done {
+			break
+		}
+
+		conn.lastSeen = timestamp
+		conn.addPacket(packet, k == conn.client, timestamp)
+		if conn.clientHello.JA3 != "" && conn.serverHello.JA3S != "" {
+			a.finish(conn)
+		}
+	}
+
+	a.expireTimedOut(timestamp)
+}
+
+// setDCID derives the connection's keys from the destination connection ID of a client's Initial packet.
+func (c *quicConnection) setDCID(packet quicPacket) bool {
+	version := quicVersions[packet.version]
+	clientKeys, err := newQUICInitialKeys(version, packet.dcid, false)
+	if err != nil {
+		return false
+	}
+	serverKeys, err := newQUICInitialKeys(version, packet.dcid, true)
+	if err != nil {
+		return false
+	}
+	c.dcid = append([]byte{}, packet.dcid...)
+	c.clientKeys, c.serverKeys = clientKeys, serverKeys
+	c.clientCrypto, c.serverCrypto = quicCryptoStream{}, quicCryptoStream{}
+	return true
+}
+
+// startsClientHello returns whether a client's Initial packet carries the start of its ClientHello.
+func (c *quicConnection) startsClientHello(packet quicPacket) bool {
+	payload, ok := c.clientKeys.decrypt(packet)
+	if !ok {
+		return false
+	}
+	for _, frame := range readQUICCryptoFrames(payload) {
+		if frame.offset == 0 && len(frame.data) > 0 && frame.data[0] == typeClientHello {
+			return true
+		}
+	}
+	return false
+}
+
+// addPacket decrypts an Initial packet and parses the ClientHello or ServerHello once all of it has been received.
+func (c *quicConnection) addPacket(packet quicPacket, fromClient bool, timestamp time.Time) {
+	keys, crypto := c.serverKeys, &c.serverCrypto
+	if fromClient {
+		keys, crypto = c.clientKeys, &c.clientCrypto
+	}
+
+	payload, ok := keys.decrypt(packet)
+	if !ok && fromClient && c.clientHello.JA3 == "" && !bytes.Equal(packet.dcid, c.dcid) {
+		// After a Retry the client starts again with a new destination connection ID
+		if !c.setDCID(packet) {
+			return
+		}
+		payload, ok = c.clientKeys.decrypt(packet)
+		crypto = &c.clientCrypto
+	}
+	if !ok {
+		return
+	}
+
+	for _, frame := range readQUICCryptoFrames(payload) {
+		crypto.add(frame.offset, frame.data)
+	}
+	for message := crypto.nextMessage(); message != nil; message = crypto.nextMessage() {
+		c.addMessage(message, fromClient, timestamp)
+	}
+}
+
+// addMessage parses a handshake message from an Initial packet.
+func (c *quicConnection) addMessage(message []byte, fromClient bool, timestamp time.Time) {
+	switch {
+	case fromClient && message[0] == typeClientHello && c.clientHello.JA3 == "":
+		msg := &clientHelloMsg{}
+		if !msg.unmarshal(message) {
+			return
+		}
+		c.clientHello.SNI = msg.serverName
+		c.clientHello.JA3, c.clientHello.JA3String = calculateJA3(msg)
+		c.clientHello.JA4, c.clientHello.JA4r = calculateJA4(msg, TransportQUIC)
+		c.clientHello.setEndpoints(c.client.net, c.client.transport)
+		c.clientHello.Time = timestamp
+		c.clientHello.Transport = TransportQUIC
+
+	case !fromClient && message[0] == typeServerHello && c.serverHello.JA3S == "":
+		msg := &serverHelloMsg{}
+		if !msg.unmarshal(message) || bytes.Equal(msg.random, helloRetryRequestRandom) {
+			// A HelloRetryRequest is followed by another ClientHello and then the real ServerHello, later in the same stream
+			return
+		}
+		c.serverHello.JA3S, c.serverHello.JA3SString = calculateJA3S(msg)
+		c.serverHello.JA4S = calculateJA4S(msg, TransportQUIC)
+		c.serverHello.setEndpoints(c.client.net, c.client.transport)
+		c.serverHello.Time = timestamp
+		c.serverHello.Transport = TransportQUIC
+	}
+}
+
+func (c *quicConnection) discard() {
+	c.clientCrypto, c.serverCrypto = quicCryptoStream{}, quicCryptoStream{}
+}
+
+// add adds the data from a CRYPTO frame to the stream.
+func (s *quicCryptoStream) add(offset uint64, data []byte) {
+	if s.length+len(data) > maxQUICCryptoLength {
+		return
+	}
+	if offset > uint64(len(s.data)) {
+		// There's a gap before this data so keep it until the gap is filled
+		if s.pending == nil {
+			s.pending = map[uint64][]byte{}
+		}
+		existing := s.pending[offset]
+		if len(existing) >= len(data) {
+			// Retransmission of data already pending
+			return
+		}
+		s.pending[offset] = append([]byte{}, data...)
+		s.length += len(data) - len(existing)
+		return
+	}
+	s.appendData(offset, data)
+
+	// Some of the pending data may now be contiguous
+	for merged := true; merged; {
+		merged = false
+		for pendingOffset, pendingData := range s.pending {
+			if pendingOffset <= uint64(len(s.data)) {
+				delete(s.pending, pendingOffset)
+				s.length -= len(pendingData)
+				s.appendData(pendingOffset, pendingData)
+				merged = true
+				break
+			}
+		}
+	}
+}
+
+// appendData appends the part of some data, which starts within the contiguous data, that hasn't already been received.
+func (s *quicCryptoStream) appendData(offset uint64, data []byte) {
+	if offset+uint64(len(data)) <= uint64(len(s.data)) {
+		// Retransmission of data already received
+		return
+	}
+	newData := data[uint64(len(s.data))-offset:]
+	s.data = append(s.data, newData...)
+	s.length += len(newData)
+}
+
+// nextMessage returns the next handshake message in the stream, or nil if it hasn't all been received yet.
+func (s *quicCryptoStream) nextMessage() []byte {
+	unread := s.data[s.read:]
+	if len(unread) < handshakeHeaderLength {
+		return nil
+	}
+	messageLength := int(unread[1])<<16 | int(unread[2])<<8 | int(unread[3])
+	if len(unread) < handshakeHeaderLength+messageLength {
+		return nil
+	}
+	s.read += handshakeHeaderLength + messageLength
+	return unread[:handshakeHeaderLength+messageLength]
+}
@@ -0,0 +1,256 @@
+package ja3assembler
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// rfc9001DCID is the client's destination connection ID in the examples of RFC 9001, Appendix A.
+var rfc9001DCID = []byte{0x83, 0x94, 0xc8, 0xf0, 0x3e, 0x51, 0x57, 0x08}
+
+// The CRYPTO frame data of the client's and server's Initial packets in RFC 9001, Appendix A.2 and A.3
+const (
+	rfc9001ClientHello = "010000ed0303ebf8fa56f12939b9584a3896472ec40bb863cfd3e86804fe3a47f06a2b69484c00000413011302010000c000000010000e00000b6578616d706c652e636f6dff01000100000a00080006001d0017001800100007000504616c706e000500050100000000003300260024001d00209370b2c9caa47fbabaf4559fedba753de171fa71f50f1ce15d43e994ec74d748002b0003020304000d0010000e0403050306030203080408050806002d00020101001c00024001003900320408ffffffffffffffff05048000ffff07048000ffff0801100104800075300901100f088394c8f03e51570806048000ffff"
+	rfc9001ServerHello = "020000560303eefce7f7b37ba1d1632e96677825ddf73988cfc79825df566dc5430b9a045a1200130100002e00330024001d00209d3c940d89690b84d08a60993c144eca684d1081287c834d5311bcf32bb9da1a002b00020304"
+)
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// cryptoFrame returns a CRYPTO frame carrying data from the given offset in the stream.
+func cryptoFrame(offset int, data []byte) []byte {
+	frame := []byte{quicFrameCrypto, 0x80, 0, 0, 0, 0x80, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[1:], 0x80000000|uint32(offset))
+	binary.BigEndian.PutUint32(frame[5:], 0x80000000|uint32(len(data)))
+	return append(frame, data...)
+}
+
+// sealQUICInitial returns a version 1 Initial packet carrying the frames, protected by keys derived from dcid.
+func sealQUICInitial(t *testing.T, dcid, scid []byte, server bool, packetNumber byte, frames []byte) []byte {
+	keys, err := newQUICInitialKeys(quicVersion1, rfc9001DCID, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// There must be enough payload to sample for the header protection
+	frames = append(frames, make([]byte, 20)...)
+
+	header := []byte{0xc0, 0, 0, 0, 1, byte(len(dcid))}
+	header = append(header, dcid...)
+	header = append(header, byte(len(scid)))
+	header = append(header, scid...)
+	length := 1 + len(frames) + keys.aead.Overhead()
+	header = append(header, 0, 0x40|byte(length>>8), byte(length)) // no token, then the length as a two byte varint
+	pnOffset := len(header)
+	header = append(header, packetNumber)
+
+	nonce := append([]byte{}, keys.iv...)
+	nonce[len(nonce)-1] ^= packetNumber
+	packet := keys.aead.Seal(header, nonce, frames, header)
+
+	mask := make([]byte, keys.hp.BlockSize())
+	keys.hp.Encrypt(mask, packet[pnOffset+4:pnOffset+20])
+	packet[0] ^= mask[0] & 0x0f
+	packet[pnOffset] ^= mask[1]
+	return packet
+}
+
+// RFC 9001, Appendix A.1 and RFC 9369, Appendix A.1
+func TestQUICInitialKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     quicVersion
+		server      bool
+		key, iv, hp string
+	}{
+		{"v1 client", quicVersion1, false, "1f369613dd76d5467730efcbe3b1a22d", "fa044b2f42a3fd3b46fb255c", "9f50449e04a0e810283a1e9933adedd2"},
+		{"v1 server", quicVersion1, true, "cf3a5331653c364c88f0f379b6067e37", "0ac1493ca1905853b0bba03e", "c206b8d9b9f0f37644430b490eeaa314"},
+		{"v2 client", quicVersion2, false, "8b1a0bc121284290a29e0971b5cd045d", "91f73e2351d8fa91660e909f", "45b95e15235d6f45a6b19cbcb0294ba9"},
+		{"v2 server", quicVersion2, true, "82db637861d55e1d011f19ea71d5d2a7", "dd13c276499c0249d3310652", "edf6d05c83121201b436e16877593c3a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, err := newQUICInitialKeys(test.version, rfc9001DCID, test.server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if iv := hex.EncodeToString(keys.iv); iv != test.iv {
+				t.Errorf("iv = %s, want %s", iv, test.iv)
+			}
+
+			// The keys themselves aren't kept so compare what they encrypt
+			block, _ := aes.NewCipher(mustDecodeHex(test.key))
+			aead, _ := cipher.NewGCM(block)
+			if want, got := aead.Seal(nil, keys.iv, nil, nil), keys.aead.Seal(nil, keys.iv, nil, nil); !bytes.Equal(got, want) {
+				t.Errorf("packet protection key isn't %s", test.key)
+			}
+			hp, _ := aes.NewCipher(mustDecodeHex(test.hp))
+			want, got := make([]byte, 16), make([]byte, 16)
+			hp.Encrypt(want, want)
+			keys.hp.Encrypt(got, got)
+			if !bytes.Equal(got, want) {
+				t.Errorf("header protection key isn't %s", test.hp)
+			}
+		})
+	}
+}
+
+// RFC 9001, Appendix A.2 and A.3
+func TestQUICDecrypt(t *testing.T) {
+	tests := []struct {
+		file   string
+		server bool
+		crypto string
+	}{
+		{"rfc9001-client-initial.hex", false, rfc9001ClientHello},
+		{"rfc9001-server-initial.hex", true, rfc9001ServerHello},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			datagram := readHex(t, test.file)
+			packet, rest, ok := parseQUICPacket(datagram)
+			if !ok || len(rest) != 0 {
+				t.Fatalf("parseQUICPacket returned ok %v with %d bytes left over", ok, len(rest))
+			}
+			if packet.version != 1 || !bytes.Equal(packet.dcid, rfc9001DCID) != test.server {
+				t.Errorf("parsed version %x and DCID %x", packet.version, packet.dcid)
+			}
+
+			keys, err := newQUICInitialKeys(quicVersion1, rfc9001DCID, test.server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, ok := keys.decrypt(packet)
+			if !ok {
+				t.Fatal("failed to decrypt packet")
+			}
+			var crypto []byte
+			for _, frame := range readQUICCryptoFrames(payload) {
+				if frame.offset != uint64(len(crypto)) {
+					t.Fatalf("CRYPTO frame at offset %d, want %d", frame.offset, len(crypto))
+				}
+				crypto = append(crypto, frame.data...)
+			}
+			if got := hex.EncodeToString(crypto); got != test.crypto {
+				t.Errorf("CRYPTO frames contain %s, want %s", got, test.crypto)
+			}
+		})
+	}
+}
+
+// The expected fingerprints were calculated by an independent implementation from the ClientHello and ServerHello in the packets.
+func TestQUIC(t *testing.T) {
+	handshakes := assemble(udpConnection([]segment{
+		{true, readHex(t, "rfc9001-client-initial.hex")},
+		{false, readHex(t, "rfc9001-server-initial.hex")},
+	}))
+	if len(handshakes) != 1 {
+		t.Fatalf("got %d handshakes, want 1", len(handshakes))
+	}
+
+	h := handshakes[0]
+	if h.SrcIP.String() != "10.0.0.1" || h.SrcPort != 50000 || h.DstIP.String() != "10.0.0.2" || h.DstPort != 443 {
+		t.Errorf("handshake from %s:%d to %s:%d, want from the client", h.SrcIP, h.SrcPort, h.DstIP, h.DstPort)
+	}
+	fields := []struct{ name, got, want string }{
+		{"Transport", h.Transport, TransportQUIC},
+		{"SNI", h.SNI, "example.com"},
+		{"JA3String", h.JA3String, "771,4865-4866,0-65281-10-16-5-51-43-13-45-28-57,29-23-24,"},
+		{"JA3", h.JA3, "41bc9ae914d6cb3bd0bd0a5453ab7d7f"},
+		{"JA4", h.JA4, "q13d0211an_62ed6f6ca7ad_4d634acda6c0"},
+		{"JA3SString", h.JA3SString, "771,4865,51-43"},
+		{"JA3S", h.JA3S, "eb1d94daa7e0344597e756a1fb6e7054"},
+		{"JA4S", h.JA4S, "q130200_1301_234ea6891581"},
+	}
+	for _, field := range fields {
+		if field.got != field.want {
+			t.Errorf("%s = %q, want %q", field.name, field.got, field.want)
+		}
+	}
+	if h.HelloLatency != 1e6 {
+		t.Errorf("HelloLatency = %v, want 1ms", h.HelloLatency)
+	}
+}
+
+// A connection must start with a client's Initial packet carrying the start of its ClientHello.
+func TestQUICNotClientHello(t *testing.T) {
+	clientHello := mustDecodeHex(rfc9001ClientHello)
+	tests := []struct {
+		name     string
+		datagram []byte
+	}{
+		{"server's Initial", readHex(t, "rfc9001-server-initial.hex")},
+		{"middle of the ClientHello", sealQUICInitial(t, rfc9001DCID, nil, false, 1, cryptoFrame(100, clientHello[100:]))},
+		{"not a ClientHello", sealQUICInitial(t, rfc9001DCID, nil, false, 0, cryptoFrame(0, mustDecodeHex(rfc9001ServerHello)))},
+		{"no CRYPTO frame", sealQUICInitial(t, rfc9001DCID, nil, false, 0, []byte{quicFramePing})},
+		{"short header", []byte{0x40, 1, 2, 3, 4, 5, 6, 7, 8}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var handshakes []Handshake
+			assembler := NewAssembler(func(handshake Handshake) {
+				handshakes = append(handshakes, handshake)
+			})
+			for _, p := range udpConnection([]segment{{true, test.datagram}}) {
+				assembler.Assemble(p)
+			}
+			if n := len(assembler.quic.connections); n != 0 {
+				t.Errorf("tracking %d connections, want none", n)
+			}
+			assembler.FlushAll()
+			if len(handshakes) != 0 {
+				t.Errorf("got handshakes %+v, want none", handshakes)
+			}
+		})
+	}
+}
+
+// After a HelloRetryRequest the client sends another ClientHello, and the real ServerHello follows in the same CRYPTO stream.
+func TestQUICHelloRetryRequest(t *testing.T) {
+	clientHello, serverHello := mustDecodeHex(rfc9001ClientHello), mustDecodeHex(rfc9001ServerHello)
+	helloRetryRequest := append([]byte{}, serverHello...)
+	copy(helloRetryRequest[6:], helloRetryRequestRandom) // after the message header and legacy version
+	scid := []byte{0xf0, 0x67, 0xa5, 0x50, 0x2a, 0x42, 0x62, 0xb5}
+
+	handshakes := assemble(udpConnection([]segment{
+		{true, sealQUICInitial(t, rfc9001DCID, nil, false, 0, cryptoFrame(0, clientHello))},
+		{false, sealQUICInitial(t, nil, scid, true, 0, cryptoFrame(0, helloRetryRequest))},
+		{true, sealQUICInitial(t, rfc9001DCID, nil, false, 1, cryptoFrame(len(clientHello), clientHello))},
+		{false, sealQUICInitial(t, nil, scid, true, 1, cryptoFrame(len(helloRetryRequest), serverHello))},
+	}))
+	if len(handshakes) != 1 {
+		t.Fatalf("got %d handshakes, want 1", len(handshakes))
+	}
+	if h := handshakes[0]; h.JA3 != "41bc9ae914d6cb3bd0bd0a5453ab7d7f" || h.JA3S != "eb1d94daa7e0344597e756a1fb6e7054" {
+		t.Errorf("got JA3 %s and JA3S %s", h.JA3, h.JA3S)
+	}
+	if h := handshakes[0]; h.HelloLatency != 3e6 {
+		t.Errorf("HelloLatency = %v, want the 3ms until the real ServerHello", h.HelloLatency)
+	}
+}
+
+// Retransmissions of CRYPTO frames received ahead of the rest of the stream mustn't count towards its limit again.
+func TestQUICCryptoStreamRetransmission(t *testing.T) {
+	message := tlsMessage(typeClientHello, make([]byte, 20000))
+	s := quicCryptoStream{}
+	for i := 0; i < 5; i++ {
+		s.add(10000, message[10000:])
+	}
+	s.add(10000, message[10000:15000]) // a shorter frame at the same offset
+	if s.length != len(message)-10000 {
+		t.Errorf("buffered %d bytes, want %d", s.length, len(message)-10000)
+	}
+
+	s.add(0, message[:10000])
+	if got := s.nextMessage(); !bytes.Equal(got, message) {
+		t.Errorf("got a %d byte message, want the %d byte ClientHello", len(got), len(message))
+	}
+}
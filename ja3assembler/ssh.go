@@ -0,0 +1,181 @@
+package ja3assembler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const (
+	// maxSSHBannerLength is the longest identification string allowed (RFC 4253, Section 4.2)
+	maxSSHBannerLength = 255
+	// maxSSHPacketLength is the longest packet that implementations must be able to handle (RFC 4253, Section 6.1)
+	maxSSHPacketLength = 35000
+
+	sshMsgKexInit = 20
+)
+
+// SSHHandshake holds the fingerprints extracted from the unencrypted start of an SSH connection.
+// Fields for a side of the connection that wasn't seen are left empty.
+type SSHHandshake struct {
+	ClientBanner string
+	ServerBanner string
+
+	// HASSH fingerprints calculated from each side's SSH_MSG_KEXINIT
+	// Spec: https://github.com/salesforce/hassh
+	HASSH                 string
+	HASSHAlgorithms       string // the string HASSH is the MD5 hash of
+	HASSHServer           string
+	HASSHServerAlgorithms string // the string HASSHServer is the MD5 hash of
+}
+
+// sshStream holds what has been parsed from one direction of an SSH connection.
+type sshStream struct {
+	banner  string
+	kexInit *sshKexInit
+}
+
+// sshKexInit holds the name-lists from an SSH_MSG_KEXINIT which go into HASSH and HASSHServer.
+type sshKexInit struct {
+	kexAlgorithms                                        string
+	encryptionClientToServer, encryptionServerToClient   string
+	macClientToServer, macServerToClient                 string
+	compressionClientToServer, compressionServerToClient string
+}
+
+func isSSH(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("SSH-"))
+}
+
+// parseSSH parses the banner and then the SSH_MSG_KEXINIT, after which everything interesting has been seen.
+func (s *unidirectionalStream) parseSSH() {
+	if s.ssh.banner == "" {
+		n := nextLine(s.unparsedRecordData)
+		if n == 0 {
+			if len(s.unparsedRecordData) > maxSSHBannerLength {
+				s.completeProcessing(false, "SSH banner too long")
+			}
+			return
+		}
+		s.ssh.banner = strings.TrimRight(string(s.unparsedRecordData[:n]), "\r\n")
+		s.unparsedRecordData = s.unparsedRecordData[n:]
+		s.handshake.Time = s.lastSeen
+	}
+
+	// Binary packets: uint32 packet_length, byte padding_length, payload, padding. There's no MAC until the keys are exchanged.
+	if len(s.unparsedRecordData) < 5 {
+		return
+	}
+	packetLength := int(binary.BigEndian.Uint32(s.unparsedRecordData))
+	if packetLength > maxSSHPacketLength {
+		s.completeProcessing(true, "SSH packet too large")
+		return
+	}
+	if len(s.unparsedRecordData) < 4+packetLength {
+		return
+	}
+	paddingLength := int(s.unparsedRecordData[4])
+	if paddingLength+1 > packetLength {
+		s.completeProcessing(true, "invalid SSH packet")
+		return
+	}
+	payload := s.unparsedRecordData[5 : 4+packetLength-paddingLength]
+
+	s.ssh.kexInit = parseSSHKexInit(payload)
+	s.completeProcessing(true, "success")
+}
+
+// parseSSHKexInit parses an SSH_MSG_KEXINIT payload (RFC 4253, Section 7.1), returning nil if it's something else.
+func parseSSHKexInit(payload []byte) *sshKexInit {
+	s := cryptobyte.String(payload)
+	var messageType uint8
+	if !s.ReadUint8(&messageType) || messageType != sshMsgKexInit || !s.Skip(16) { // cookie
+		return nil
+	}
+
+	var hostKeyAlgorithms string
+	k := &sshKexInit{}
+	for _, nameList := range []*string{
+		&k.kexAlgorithms,
+		&hostKeyAlgorithms,
+		&k.encryptionClientToServer, &k.encryptionServerToClient,
+		&k.macClientToServer, &k.macServerToClient,
+		&k.compressionClientToServer, &k.compressionServerToClient,
+	} {
+		var length uint32
+		var value []byte
+		if !s.ReadUint32(&length) || !s.ReadBytes(&value, int(length)) {
+			return nil
+		}
+		*nameList = string(value)
+	}
+	return k
+}
+
+// hassh returns the HASSH fingerprint of a client's SSH_MSG_KEXINIT and the string it's the hash of.
+func (k *sshKexInit) hassh() (hassh, algorithms string) {
+	algorithms = strings.Join([]string{k.kexAlgorithms, k.encryptionClientToServer, k.macClientToServer, k.compressionClientToServer}, ";")
+	return md5Hex(algorithms), algorithms
+}
+
+// hasshServer returns the HASSHServer fingerprint of a server's SSH_MSG_KEXINIT and the string it's the hash of.
+func (k *sshKexInit) hasshServer() (hasshServer, algorithms string) {
+	algorithms = strings.Join([]string{k.kexAlgorithms, k.encryptionServerToClient, k.macServerToClient, k.compressionServerToClient}, ";")
+	return md5Hex(algorithms), algorithms
+}
+
+// finishSSH reports the fingerprints of an SSH connection once both directions have finished.
+func (bd *bidirectionalStream) finishSSH() {
+	client, server, aIsClient := bd.clientAndServer()
+
+	var handshake Handshake
+	if aIsClient {
+		handshake.setEndpoints(bd.a.net, bd.a.transport)
+	} else {
+		handshake.setEndpoints(bd.a.net.Reverse(), bd.a.transport.Reverse())
+	}
+	handshake.Transport = TransportTCP
+	handshake.SSH = &SSHHandshake{}
+
+	for _, s := range []*unidirectionalStream{client, server} {
+		if s.ssh == nil {
+			continue
+		}
+		if handshake.Time.IsZero() || (!s.handshake.Time.IsZero() && s.handshake.Time.Before(handshake.Time)) {
+			handshake.Time = s.handshake.Time
+		}
+	}
+	if client.ssh != nil {
+		handshake.SSH.ClientBanner = client.ssh.banner
+		if client.ssh.kexInit != nil {
+			handshake.SSH.HASSH, handshake.SSH.HASSHAlgorithms = client.ssh.kexInit.hassh()
+		}
+	}
+	if server.ssh != nil {
+		handshake.SSH.ServerBanner = server.ssh.banner
+		if server.ssh.kexInit != nil {
+			handshake.SSH.HASSHServer, handshake.SSH.HASSHServerAlgorithms = server.ssh.kexInit.hasshServer()
+		}
+	}
+	bd.report(handshake)
+}
+
+// clientAndServer works out which of the streams was sent by the client, and whether that's a.
+// Both sides of an SSH connection look the same so this relies on having seen the connection being opened.
+func (bd *bidirectionalStream) clientAndServer() (client, server *unidirectionalStream, aIsClient bool) {
+	switch {
+	case bd.a.sentSYN || bd.b.sentSYNACK:
+		return bd.a, bd.b, true
+	case bd.b.sentSYN || bd.a.sentSYNACK:
+		return bd.b, bd.a, false
+	}
+
+	// Otherwise guess that the server is the side with the lower port number
+	srcPort, dstPort := binary.BigEndian.Uint16(bd.a.transport.Src().Raw()), binary.BigEndian.Uint16(bd.a.transport.Dst().Raw())
+	if dstPort <= srcPort {
+		return bd.a, bd.b, true
+	}
+	return bd.b, bd.a, false
+}
@@ -0,0 +1,139 @@
+package ja3assembler
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// sshPacket returns an unencrypted SSH binary packet carrying the payload, padded to a multiple of 8 bytes.
+func sshPacket(payload []byte) []byte {
+	padding := 8 - (5+len(payload))%8
+	if padding < 4 {
+		padding += 8
+	}
+	packet := make([]byte, 5, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(packet, uint32(1+len(payload)+padding))
+	packet[4] = byte(padding)
+	packet = append(packet, payload...)
+	return append(packet, make([]byte, padding)...)
+}
+
+// sshKexInitPacket returns an SSH_MSG_KEXINIT packet with the ten name-lists, of which the first eight are given.
+func sshKexInitPacket(nameLists ...string) []byte {
+	payload := append([]byte{sshMsgKexInit}, make([]byte, 16)...) // cookie
+	for _, nameList := range append(nameLists, "", "") {          // then no languages
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(nameList)))
+		payload = append(payload, length...)
+		payload = append(payload, nameList...)
+	}
+	payload = append(payload, 0, 0, 0, 0, 0) // first_kex_packet_follows and reserved
+	return sshPacket(payload)
+}
+
+// The expected fingerprints are the MD5 hashes of the algorithms, calculated by an independent implementation.
+func TestSSH(t *testing.T) {
+	clientKexInit := sshKexInitPacket(
+		"curve25519-sha256,diffie-hellman-group14-sha256", "ssh-ed25519",
+		"aes128-ctr,aes256-ctr", "aes256-gcm@openssh.com",
+		"hmac-sha2-256", "hmac-sha1",
+		"none,zlib@openssh.com", "none",
+	)
+	serverKexInit := sshKexInitPacket(
+		"curve25519-sha256", "ssh-ed25519,rsa-sha2-512",
+		"chacha20-poly1305@openssh.com", "aes128-gcm@openssh.com,aes256-ctr",
+		"umac-64@openssh.com", "hmac-sha2-512",
+		"none", "none,zlib",
+	)
+	// The server sends its banner first and the client's SSH_MSG_KEXINIT is split across segments
+	handshakes := assemble(tcpConnection([]segment{
+		{false, []byte("SSH-2.0-OpenSSH_9.6\r\n")},
+		{true, []byte("SSH-2.0-Go\r\n")},
+		{true, clientKexInit[:10]},
+		{false, serverKexInit},
+		{true, clientKexInit[10:]},
+	}))
+	if len(handshakes) != 1 {
+		t.Fatalf("got %d handshakes, want 1", len(handshakes))
+	}
+
+	h := handshakes[0]
+	if h.SrcIP.String() != "10.0.0.1" || h.SrcPort != 50000 || h.DstIP.String() != "10.0.0.2" || h.DstPort != 443 {
+		t.Errorf("handshake from %s:%d to %s:%d, want from the client", h.SrcIP, h.SrcPort, h.DstIP, h.DstPort)
+	}
+	if h.SSH == nil || h.JA3 != "" || h.Transport != TransportTCP {
+		t.Fatalf("unexpected handshake %+v", h)
+	}
+	fields := []struct{ name, got, want string }{
+		{"ClientBanner", h.SSH.ClientBanner, "SSH-2.0-Go"},
+		{"ServerBanner", h.SSH.ServerBanner, "SSH-2.0-OpenSSH_9.6"},
+		{"HASSHAlgorithms", h.SSH.HASSHAlgorithms, "curve25519-sha256,diffie-hellman-group14-sha256;aes128-ctr,aes256-ctr;hmac-sha2-256;none,zlib@openssh.com"},
+		{"HASSH", h.SSH.HASSH, "3c22e3bfc2b8b121088e0bc23a380321"},
+		{"HASSHServerAlgorithms", h.SSH.HASSHServerAlgorithms, "curve25519-sha256;aes128-gcm@openssh.com,aes256-ctr;hmac-sha2-512;none,zlib"},
+		{"HASSHServer", h.SSH.HASSHServer, "90896536eb5077a95d3969384e398f2a"},
+	}
+	for _, field := range fields {
+		if field.got != field.want {
+			t.Errorf("%s = %q, want %q", field.name, field.got, field.want)
+		}
+	}
+	if !h.Time.Equal(testStart.Add(3 * time.Millisecond)) {
+		t.Errorf("Time = %v, want when the server's banner was sent", h.Time)
+	}
+}
+
+// If the client's SYN is missed its side of the connection can't be reassembled,
+// but the SYN-ACK still shows which side is the server.
+func TestSSHMissedSYN(t *testing.T) {
+	packets := tcpConnection([]segment{
+		{false, []byte("SSH-2.0-OpenSSH_9.6\r\n")},
+		{true, []byte("SSH-2.0-Go\r\n")},
+	})
+	handshakes := assemble(packets[1:])
+	if len(handshakes) != 1 {
+		t.Fatalf("got %d handshakes, want 1", len(handshakes))
+	}
+	h := handshakes[0]
+	if h.SrcPort != 50000 || h.DstPort != 443 {
+		t.Errorf("handshake from port %d to %d, want from the client", h.SrcPort, h.DstPort)
+	}
+	if h.SSH == nil || h.SSH.ClientBanner != "" || h.SSH.ServerBanner != "SSH-2.0-OpenSSH_9.6" {
+		t.Errorf("unexpected SSH handshake %+v", h.SSH)
+	}
+}
+
+// Only the banners are reported if the first packet isn't an SSH_MSG_KEXINIT.
+func TestSSHNotKexInit(t *testing.T) {
+	handshakes := assemble(tcpConnection([]segment{
+		{true, append([]byte("SSH-2.0-Go\r\n"), sshPacket([]byte{sshMsgKexInit + 1, 1, 2, 3})...)},
+		{false, []byte("SSH-2.0-OpenSSH_9.6\r\n")},
+	}))
+	if len(handshakes) != 1 {
+		t.Fatalf("got %d handshakes, want 1", len(handshakes))
+	}
+	h := handshakes[0].SSH
+	if h == nil || h.ClientBanner != "SSH-2.0-Go" || h.ServerBanner != "SSH-2.0-OpenSSH_9.6" || h.HASSH != "" || h.HASSHServer != "" {
+		t.Errorf("unexpected SSH handshake %+v", h)
+	}
+}
+
+func TestParseSSHKexInit(t *testing.T) {
+	packet := sshKexInitPacket("kex", "hostkey", "enc-cs", "enc-sc", "mac-cs", "mac-sc", "comp-cs", "comp-sc")
+	payload := packet[5 : len(packet)-int(packet[4])]
+	want := sshKexInit{
+		kexAlgorithms:             "kex",
+		encryptionClientToServer:  "enc-cs",
+		encryptionServerToClient:  "enc-sc",
+		macClientToServer:         "mac-cs",
+		macServerToClient:         "mac-sc",
+		compressionClientToServer: "comp-cs",
+		compressionServerToClient: "comp-sc",
+	}
+	if got := parseSSHKexInit(payload); got == nil || *got != want {
+		t.Errorf("parseSSHKexInit() = %+v, want %+v", got, want)
+	}
+	if got := parseSSHKexInit(payload[:40]); got != nil {
+		t.Errorf("parseSSHKexInit() of a truncated payload = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,260 @@
+package ja3assembler
+
+import (
+	"bytes"
+)
+
+const (
+	// maxPlaintextLength limits how much plaintext is read before a connection upgrades to TLS,
+	// so that connections which never upgrade don't have their data buffered indefinitely.
+	maxPlaintextLength = 64 << 10
+	// maxPlaintextUnitLength limits the length of a single line (or equivalent) of plaintext.
+	maxPlaintextUnitLength = 8 << 10
+	// detectionLength is how many bytes are needed to recognise every supported plaintext protocol.
+	detectionLength = 16
+)
+
+// ldapStartTLSOID is the name of the LDAP extended operation which upgrades the connection to TLS (RFC 4511, Section 4.14).
+var ldapStartTLSOID = []byte("1.3.6.1.4.1.1466.20037")
+
+// plaintextProtocol is a protocol in which a plaintext connection can be upgraded to TLS.
+// Despite the name, this includes binary protocols like LDAP.
+type plaintextProtocol struct {
+	name string
+	// serverGreets is whether the server speaks first. Its greeting is never the switch to TLS,
+	// even if it looks like a successful response (e.g. SMTP's 220).
+	serverGreets bool
+	// nextUnit returns the length of the first complete unit (e.g. line) of data, or zero if it's incomplete.
+	nextUnit func(data []byte) int
+	// isUpgrade returns whether a unit is the command (if sent by the client) or successful response
+	// (if sent by the server) after which the connection switches to TLS.
+	isUpgrade func(unit []byte, client bool) bool
+}
+
+var (
+	smtp = &plaintextProtocol{"smtp", true, nextLine, func(unit []byte, client bool) bool {
+		if client {
+			return lineIs(unit, "STARTTLS")
+		}
+		return bytes.HasPrefix(unit, []byte("220"))
+	}}
+	ftp = &plaintextProtocol{"ftp", true, nextLine, func(unit []byte, client bool) bool {
+		if client {
+			return lineIs(unit, "AUTH TLS") || lineIs(unit, "AUTH SSL") || lineIs(unit, "AUTH TLS-C")
+		}
+		return bytes.HasPrefix(unit, []byte("234"))
+	}}
+	imap = &plaintextProtocol{"imap", true, nextLine, func(unit []byte, client bool) bool {
+		// Commands and their responses are prefixed with a tag e.g. "a1 STARTTLS" and "a1 OK Begin TLS"
+		fields := bytes.Fields(bytes.ToUpper(unit))
+		if len(fields) < 2 {
+			return false
+		}
+		if client {
+			return len(fields) == 2 && string(fields[1]) == "STARTTLS"
+		}
+		return string(fields[0]) != "*" && string(fields[1]) == "OK"
+	}}
+	pop3 = &plaintextProtocol{"pop3", true, nextLine, func(unit []byte, client bool) bool {
+		if client {
+			return lineIs(unit, "STLS")
+		}
+		return bytes.HasPrefix(unit, []byte("+OK"))
+	}}
+	xmpp = &plaintextProtocol{"xmpp", false, nextXMLTag, func(unit []byte, _ bool) bool {
+		// The server also sends a <starttls> element to advertise the feature but it's never followed by TLS
+		return bytes.HasPrefix(unit, []byte("<starttls")) || bytes.HasPrefix(unit, []byte("<proceed"))
+	}}
+	ldap = &plaintextProtocol{"ldap", false, nextBERElement, isLDAPStartTLS}
+)
+
+// detectPlaintextProtocol recognises the start of a plaintext protocol which can be upgraded to TLS.
+// It returns which protocol this is and whether the data was sent by the client.
+func detectPlaintextProtocol(data []byte) (protocol *plaintextProtocol, client bool) {
+	upper := bytes.ToUpper(data)
+	hasPrefix := func(prefixes ...string) bool {
+		for _, prefix := range prefixes {
+			if bytes.HasPrefix(upper, []byte(prefix)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case hasPrefix("EHLO ", "HELO "):
+		return smtp, true
+	case hasPrefix("220") && nextLine(upper) > 0:
+		// Both SMTP and FTP servers greet with a 220 but FTP servers normally say who they are
+		if bytes.Contains(upper[:nextLine(upper)], []byte("FTP")) {
+			return ftp, false
+		}
+		return smtp, false
+	case hasPrefix("AUTH TLS", "AUTH SSL", "FEAT", "USER "):
+		return ftp, true
+	case hasPrefix("* OK", "* PREAUTH"):
+		return imap, false
+	case hasPrefix("+OK"):
+		return pop3, false
+	case hasPrefix("CAPA", "STLS"):
+		return pop3, true
+	case hasPrefix("<?XML", "<STREAM:STREAM"):
+		// The client and server both open with a stream header. Which one sent it doesn't matter.
+		return xmpp, true
+	}
+
+	// The database protocols are binary so could otherwise be mistaken for LDAP
+	if protocol, client := detectDatabaseProtocol(data); protocol != nil {
+		return protocol, client
+	}
+
+	switch {
+	case len(data) > 0 && data[0] == 0x30:
+		// An LDAPMessage is a BER sequence. LDAP servers don't normally speak first.
+		return ldap, true
+	}
+
+	// IMAP clients start with a tagged command such as "a1 CAPABILITY"
+	if fields := bytes.Fields(upper); len(fields) >= 2 {
+		switch string(fields[1]) {
+		case "CAPABILITY", "STARTTLS":
+			return imap, true
+		}
+	}
+	return nil, false
+}
+
+// parsePlaintext consumes the plaintext part of the stream before it upgrades to TLS.
+// It returns true once the TLS records have started.
+func (s *unidirectionalStream) parsePlaintext() bool {
+	for {
+		if s.protocol == nil {
+			if len(s.unparsedRecordData) > 0 && s.unparsedRecordData[0] == recordTypeHandshake {
+				// The stream started with TLS
+				return true
+			}
+			s.protocol, s.client = detectPlaintextProtocol(s.unparsedRecordData)
+			if s.protocol == nil {
+				if len(s.unparsedRecordData) < detectionLength && nextLine(s.unparsedRecordData) == 0 {
+					// Not enough data to work out the protocol yet
+					return false
+				}
+				s.completeProcessing(false, "unsupported protocol %q", s.unparsedRecordData[:nextLine(s.unparsedRecordData)])
+				return false
+			}
+		}
+
+		if s.upgraded && len(s.unparsedRecordData) >= 2 {
+			if s.unparsedRecordData[0] == recordTypeHandshake && s.unparsedRecordData[1] == 0x03 {
+				return true
+			}
+			// The upgrade must have been refused or not taken effect yet
+			s.upgraded = false
+		}
+
+		n := s.protocol.nextUnit(s.unparsedRecordData)
+		if n == 0 {
+			if len(s.unparsedRecordData) > maxPlaintextUnitLength {
+				s.completeProcessing(false, "%s line too long", s.protocol.name)
+			}
+			return false
+		}
+		s.plaintextLength += n
+		if s.plaintextLength > maxPlaintextLength {
+			s.completeProcessing(false, "%s never upgraded to TLS", s.protocol.name)
+			return false
+		}
+
+		unit := s.unparsedRecordData[:n]
+		s.unparsedRecordData = s.unparsedRecordData[n:]
+		if !s.client && s.protocol.serverGreets && s.plaintextLength == n {
+			// The server's greeting
+			continue
+		}
+		s.upgraded = s.protocol.isUpgrade(unit, s.client)
+		if !s.client && s.protocol == smtp && bytes.HasPrefix(unit, []byte("234")) {
+			// The response to AUTH TLS, so this was actually FTP
+			s.protocol, s.upgraded = ftp, true
+		}
+	}
+}
+
+func nextLine(data []byte) int {
+	return bytes.IndexByte(data, '\n') + 1
+}
+
+func lineIs(line []byte, command string) bool {
+	return string(bytes.ToUpper(bytes.TrimSpace(line))) == command
+}
+
+// nextXMLTag returns the length of data up to the end of the first XML tag, including any preceding text.
+func nextXMLTag(data []byte) int {
+	start := bytes.IndexByte(data, '<')
+	if start < 0 {
+		return 0
+	}
+	if start > 0 {
+		// Whitespace between tags
+		return start
+	}
+	return bytes.IndexByte(data, '>') + 1
+}
+
+// nextBERElement returns the length of the first BER encoded element.
+func nextBERElement(data []byte) int {
+	_, _, length := readBERElement(data)
+	return length
+}
+
+// readBERElement parses the tag and content of the first BER encoded element, also returning
+// the element's total length. The length is zero if the element is incomplete or invalid.
+func readBERElement(data []byte) (tag byte, content []byte, length int) {
+	if len(data) < 2 {
+		return 0, nil, 0
+	}
+	tag = data[0]
+	headerLength, contentLength := 2, int(data[1])
+	if data[1]&0x80 != 0 {
+		// Long form: the low bits say how many bytes the length takes up
+		lengthBytes := int(data[1] & 0x7f)
+		if lengthBytes == 0 || lengthBytes > 3 || len(data) < 2+lengthBytes {
+			return 0, nil, 0
+		}
+		contentLength = 0
+		for _, b := range data[2 : 2+lengthBytes] {
+			contentLength = contentLength<<8 | int(b)
+		}
+		headerLength += lengthBytes
+	}
+	if len(data) < headerLength+contentLength {
+		return 0, nil, 0
+	}
+	return tag, data[headerLength : headerLength+contentLength], headerLength + contentLength
+}
+
+// isLDAPStartTLS returns whether an LDAPMessage is a StartTLS extended request or a successful response to one.
+// Both directions start with an LDAPMessage so the operation, rather than which side it came from, decides this.
+func isLDAPStartTLS(message []byte, _ bool) bool {
+	// LDAPMessage ::= SEQUENCE { messageID INTEGER, protocolOp CHOICE { ... }, ... }
+	_, body, _ := readBERElement(message)
+	_, _, n := readBERElement(body) // messageID
+	if n == 0 {
+		return false
+	}
+	opTag, op, _ := readBERElement(body[n:])
+
+	const (
+		extendedRequest  = 0x77 // [APPLICATION 23] constructed
+		extendedResponse = 0x78 // [APPLICATION 24] constructed
+		enumerated       = 0x0a
+		success          = 0
+	)
+	switch opTag {
+	case extendedRequest:
+		return bytes.Contains(op, ldapStartTLSOID)
+	case extendedResponse:
+		resultTag, result, _ := readBERElement(op)
+		return resultTag == enumerated && len(result) == 1 && result[0] == success
+	}
+	return false
+}
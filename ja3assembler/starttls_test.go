@@ -0,0 +1,119 @@
+package ja3assembler
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+var (
+	ldapStartTLSRequest  = append([]byte{0x30, 0x1d, 0x02, 0x01, 0x01, 0x77, 0x18, 0x80, 0x16}, ldapStartTLSOID...)
+	ldapStartTLSResponse = []byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00}
+)
+
+func TestStartTLS(t *testing.T) {
+	tests := []struct {
+		protocol  string
+		plaintext []segment
+	}{
+		{"smtp", []segment{
+			{false, []byte("220 mx.example.com ESMTP ready\r\n")},
+			{true, []byte("EHLO client.example.com\r\n")},
+			{false, []byte("250-mx.example.com\r\n250 STARTTLS\r\n")},
+			{true, []byte("STARTTLS\r\n")},
+			{false, []byte("220 2.0.0 Ready to start TLS\r\n")},
+		}},
+		{"ftp", []segment{
+			{false, []byte("220 ProFTPD Server ready\r\n")},
+			{true, []byte("AUTH TLS\r\n")},
+			{false, []byte("234 AUTH TLS successful\r\n")},
+		}},
+		{"ftp", []segment{
+			// Without the server saying it's FTP, this looks like SMTP until the response to AUTH TLS
+			{false, []byte("220 Service ready\r\n")},
+			{true, []byte("AUTH TLS\r\n")},
+			{false, []byte("234 Proceed\r\n")},
+		}},
+		{"imap", []segment{
+			{false, []byte("* OK IMAP4rev1 ready\r\n")},
+			{true, []byte("a1 STARTTLS\r\n")},
+			{false, []byte("a1 OK Begin TLS negotiation now\r\n")},
+		}},
+		{"pop3", []segment{
+			{false, []byte("+OK POP3 ready\r\n")},
+			{true, []byte("STLS\r\n")},
+			{false, []byte("+OK Begin TLS negotiation\r\n")},
+		}},
+		{"xmpp", []segment{
+			{true, []byte("<?xml version='1.0'?><stream:stream to='example.com' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>")},
+			{false, []byte("<?xml version='1.0'?><stream:stream from='example.com' id='abc' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>")},
+			{false, []byte("<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls></stream:features>")},
+			{true, []byte("<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")},
+			{false, []byte("<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")},
+		}},
+		{"ldap", []segment{
+			{true, ldapStartTLSRequest},
+			{false, ldapStartTLSResponse},
+		}},
+	}
+	for _, test := range tests {
+		for name, version := range map[string]uint16{"TLS 1.2": tls.VersionTLS12, "TLS 1.3": tls.VersionTLS13} {
+			t.Run(test.protocol+" "+name, func(t *testing.T) {
+				segments := append(append([]segment{}, test.plaintext...), testTLSHandshake(t, version)...)
+				handshakes := assemble(tcpConnection(segments))
+				if len(handshakes) != 1 {
+					t.Fatalf("got %d handshakes, want 1", len(handshakes))
+				}
+				h := handshakes[0]
+				if h.Protocol != test.protocol || h.JA3 == "" || h.JA3S == "" {
+					t.Errorf("got protocol %q, JA3 %q and JA3S %q", h.Protocol, h.JA3, h.JA3S)
+				}
+			})
+		}
+	}
+}
+
+func TestStartTLSRefused(t *testing.T) {
+	handshakes := assemble(tcpConnection([]segment{
+		{false, []byte("220 mx.example.com ESMTP ready\r\n")},
+		{true, []byte("STARTTLS\r\n")},
+		{false, []byte("454 TLS not available\r\n")},
+		{true, []byte("QUIT\r\n")},
+		{false, []byte("221 Bye\r\n")},
+	}))
+	if len(handshakes) != 0 {
+		t.Errorf("got %+v from a connection which never upgraded", handshakes)
+	}
+}
+
+func TestParsePlaintext(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		protocol *plaintextProtocol
+		client   bool
+		upgraded bool
+	}{
+		{"SMTP greeting", "220 mx.example.com ESMTP ready\r\n", smtp, false, false},
+		{"SMTP greeting then response to STARTTLS", "220 mx.example.com ESMTP ready\r\n250 STARTTLS\r\n220 Go ahead\r\n", smtp, false, true},
+		{"FTP greeting", "220 ProFTPD Server ready\r\n", ftp, false, false},
+		{"POP3 greeting", "+OK POP3 ready\r\n", pop3, false, false},
+		{"IMAP greeting", "* OK IMAP4rev1 ready\r\n", imap, false, false},
+		{"SMTP STARTTLS", "EHLO client\r\nSTARTTLS \r\n", smtp, true, true},
+		{"POP3 STLS", "CAPA\r\nstls\r\n", pop3, true, true},
+		{"XMPP server advertising STARTTLS", "<stream:stream from='example.com' id='abc'><stream:features>", xmpp, true, false},
+		{"XMPP server proceeding", "<stream:stream from='example.com' id='abc'><proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>", xmpp, true, true},
+		{"LDAP StartTLS", string(ldapStartTLSRequest), ldap, true, true},
+		{"incomplete SMTP greeting", "220 mx.exam", nil, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &unidirectionalStream{unparsedRecordData: []byte(test.data)}
+			if s.parsePlaintext() {
+				t.Fatal("TLS records started")
+			}
+			if s.protocol != test.protocol || s.client != test.client || s.upgraded != test.upgraded {
+				t.Errorf("got protocol %v, client %v, upgraded %v", s.protocol, s.client, s.upgraded)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/tcpassembly"
 )
 
@@ -21,28 +22,38 @@ func (k key) String() string {
 	return fmt.Sprintf("%v:%v", k.net, k.transport)
 }
 
-// timeout is the length of time to wait before flushing connections and
-// bidirectional stream pairs.
-const timeout = 5 * time.Minute
-
 // bidirectionalStream stores each unidirectional side of a bidirectional stream.
 //
 // When a new stream comes in, if we don't have an opposite stream, a bidirectionalStream is
 // created with 'a' set to the new stream.  If we DO have an opposite stream,
 // 'b' is set to the new stream.
 type bidirectionalStream struct {
-	key            key                         // Key of the first stream, mostly for logging.
-	a, b           *unidirectionalStream       // the two unidirectional streams.
-	lastPacketSeen time.Time                   // last time we saw a packet from either stream.
-	callback       func(ja3, ja3s, sni string) // called when both directions have finished parsing their handshake
+	key            key                   // Key of the first stream, mostly for logging.
+	a, b           *unidirectionalStream // the two unidirectional streams.
+	lastPacketSeen time.Time             // last time we saw a packet from either stream.
+	ackSeen        time.Time             // capture time of the ACK which completed the TCP handshake
+	factory        *assembler            // the factory which created the streams
+	callback       func(Handshake)       // called when both directions have finished parsing their handshake
+}
+
+// capturedSegment is a TCP segment along with the details of the packet it was captured in.
+type capturedSegment struct {
+	tcp  *layers.TCP
+	ttl  uint8
+	seen time.Time
 }
 
 // myFactory implements tcpassembly.StreamFactory
 type assembler struct {
 	sync.Mutex
-	callback func(ja3, ja3s, sni string)
+	callback func(Handshake)
+	// current is the segment being assembled, so that New can tell whether a stream starts by opening the connection
+	current *capturedSegment
 	// unmatchedStreams allows us to look upmaps keys to bidirectional stream pairs.
 	unmatchedStreams map[key]*bidirectionalStream
+	// connecting maps the client's key of connections whose SYN-ACK has been seen to their bidirectional
+	// stream until the ACK completing the TCP handshake is seen.
+	connecting map[key]*bidirectionalStream
 }
 
 // New handles creating a new tcpassembly.Stream.
@@ -51,7 +62,11 @@ func (f *assembler) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
 	defer f.Unlock()
 
 	// Create a new stream.
-	s := &unidirectionalStream{}
+	s := &unidirectionalStream{net: netFlow, transport: tcpFlow}
+	if f.current != nil && f.current.tcp.SYN {
+		s.sentSYN, s.sentSYNACK = !f.current.tcp.ACK, f.current.tcp.ACK
+		s.ja4t, s.ttl, s.openedAt = calculateJA4T(f.current.tcp), f.current.ttl, f.current.seen
+	}
 
 	netFlow.EndpointType()
 	// Find the bidirectionalStream bidirectional struct for this stream, creating a new one if
@@ -59,7 +74,11 @@ func (f *assembler) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
 	k := key{netFlow, tcpFlow}
 	bd := f.unmatchedStreams[k]
 	if bd == nil {
-		bd = &bidirectionalStream{a: s, key: k, callback: f.callback}
+		bd = &bidirectionalStream{a: s, key: k, factory: f, callback: f.callback}
+		if f.current != nil {
+			// So that a stream which hasn't carried any data yet isn't collected straight away
+			bd.lastPacketSeen = f.current.seen
+		}
 		// Register bidirectional with the reverse key, so the matching stream going
 		// the other direction will find it.
 		f.unmatchedStreams[key{netFlow.Reverse(), tcpFlow.Reverse()}] = bd
@@ -67,23 +86,43 @@ func (f *assembler) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
 		bd.b = s
 		// Clear out the bidirectionalStream we're using from the map, just in case.
 		delete(f.unmatchedStreams, k)
+		if s.sentSYNACK && bd.a.sentSYN {
+			f.connecting[key{netFlow.Reverse(), tcpFlow.Reverse()}] = bd
+		}
 	}
 	s.bidi = bd
 	return s
 }
 
+// handleACK records when the ACK which completes a connection's TCP handshake was captured.
+// Packets without a payload aren't passed on to the streams so this has to be done before reassembly.
+func (f *assembler) handleACK(netFlow gopacket.Flow, segment *capturedSegment) {
+	if segment.tcp.SYN || !segment.tcp.ACK {
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+
+	k := key{netFlow, segment.tcp.TransportFlow()}
+	if bd := f.connecting[k]; bd != nil {
+		bd.ackSeen = segment.seen
+		delete(f.connecting, k)
+	}
+}
+
 // emptyStream is used to finish bidirectionalStream that only have one stream, in
 // collectOldStreams.
 var emptyStream = &unidirectionalStream{done: true}
 
-// collectOldStreams finds any streams that haven't received a packet within
-// 'timeout', and sets/finishes the 'b' stream inside them.  The 'a' stream may
+// collectOldStreams finds any streams that haven't received a packet since
+// the cutoff, and sets/finishes the 'b' stream inside them.  The 'a' stream may
 // still receive packets after this.
-func (f *assembler) collectOldStreams() {
-	cutoff := time.Now().Add(-timeout)
+func (f *assembler) collectOldStreams(cutoff time.Time, verbose bool) {
 	for k, bd := range f.unmatchedStreams {
 		if bd.lastPacketSeen.Before(cutoff) {
-			log.Printf("[%v] timing out old stream", bd.key)
+			if verbose {
+				log.Printf("[%v] timing out old stream", bd.key)
+			}
 			bd.b = emptyStream            // stub out b with an empty stream.
 			delete(f.unmatchedStreams, k) // remove it from our map.
 			bd.maybeFinish()              // if b was the last stream we were waiting for, finish up.
@@ -102,32 +141,83 @@ func (bd *bidirectionalStream) maybeFinish() {
 		// We've seen both streams but one isn't finished processing yet
 		return
 	}
+	bd.factory.Lock()
+	delete(bd.factory.connecting, bd.key)
+	delete(bd.factory.connecting, key{bd.key.net.Reverse(), bd.key.transport.Reverse()})
+	bd.factory.Unlock()
+
+	if bd.a.ssh != nil || bd.b.ssh != nil {
+		bd.finishSSH()
+		return
+	}
+	if bd.a.http != nil || bd.b.http != nil || bd.a.http2 != nil || bd.b.http2 != nil {
+		bd.finishHTTP()
+		return
+	}
 
 	// Both sides have finished so work out which was the client and which was the server
-	var ja3, ja3s, sni string
+	var handshake Handshake
 	switch {
 	case bd.a.succeeded && bd.b.succeeded:
 		switch {
-		case bd.a.ja3 != "" && bd.b.ja3s != "": // A = Client, B = Server
-			ja3, ja3s, sni = bd.a.ja3, bd.b.ja3s, bd.a.sni
+		case bd.a.handshake.JA3 != "" && bd.b.handshake.JA3S != "": // A = Client, B = Server
+			handshake = bd.a.handshake
+			handshake.merge(bd.b.handshake)
 
-		case bd.a.ja3s != "" && bd.b.ja3 != "": // A = Server, B = Client
-			ja3, ja3s, sni = bd.b.ja3, bd.a.ja3s, bd.b.sni
+		case bd.a.handshake.JA3S != "" && bd.b.handshake.JA3 != "": // A = Server, B = Client
+			handshake = bd.b.handshake
+			handshake.merge(bd.a.handshake)
 
 		default:
 			panic("impossible ja3/ja3s combination")
 		}
 
 	case bd.a.succeeded && !bd.b.succeeded:
-		ja3, ja3s, sni = bd.a.ja3, bd.a.ja3s, bd.a.sni
+		handshake = bd.a.handshake
 
 	case !bd.a.succeeded && bd.b.succeeded:
-		ja3, ja3s, sni = bd.b.ja3, bd.b.ja3s, bd.b.sni
+		handshake = bd.b.handshake
 
 	case !bd.a.succeeded && !bd.b.succeeded:
 		// Neither succeeded... guess this wasn't a TLS handshake after all
 		return
 	}
 
-	bd.callback(ja3, ja3s, sni)
+	bd.report(handshake)
+}
+
+// report adds the fingerprints of the SYN and SYN-ACK which opened the connection to a handshake
+// before passing it to the callback.
+func (bd *bidirectionalStream) report(handshake Handshake) {
+	var syn, synACK *unidirectionalStream
+	for _, s := range []*unidirectionalStream{bd.a, bd.b} {
+		switch {
+		case s.sentSYN:
+			syn = s
+			handshake.JA4T, handshake.ClientTTL = s.ja4t, s.ttl
+		case s.sentSYNACK:
+			synACK = s
+			handshake.JA4TS, handshake.ServerTTL = s.ja4t, s.ttl
+		}
+	}
+
+	if syn != nil && synACK != nil {
+		handshake.SYNToSYNACK = synACK.openedAt.Sub(syn.openedAt)
+		if !bd.ackSeen.IsZero() {
+			handshake.SYNACKToACK = bd.ackSeen.Sub(synACK.openedAt)
+		}
+		handshake.JA4L, handshake.JA4LS = calculateJA4L(handshake)
+	}
+	for _, s := range []*unidirectionalStream{bd.a, bd.b} {
+		if s.handshakeFinished.IsZero() {
+			continue
+		}
+		// Time the whole handshake from the SYN if it was seen, otherwise from the ClientHello
+		start := s.handshake.Time
+		if syn != nil {
+			start = syn.openedAt
+		}
+		handshake.HandshakeDuration = s.handshakeFinished.Sub(start)
+	}
+	bd.callback(handshake)
 }
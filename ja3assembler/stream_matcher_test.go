@@ -0,0 +1,138 @@
+package ja3assembler
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// tcpConnection's SYN has a window of 64240 and only an MSS of 1460, and its SYN-ACK has a window of 65160
+// and no options. The client's packets have a TTL of 64 and the server's 56.
+func TestJA4T(t *testing.T) {
+	packets := tcpConnection(testTLSHandshake(t, tls.VersionTLS12))
+	tests := []struct {
+		name                 string
+		without              int // the index of a packet which wasn't captured
+		ja4t, ja4ts          string
+		clientTTL, serverTTL uint8
+	}{
+		{"all packets", -1, "64240_2_1460_00", "65160_00_00_00", 64, 56},
+		{"SYN-ACK missed", 1, "64240_2_1460_00", "", 64, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			captured := append(packets[:0:0], packets...)
+			if test.without >= 0 {
+				captured = append(captured[:test.without], captured[test.without+1:]...)
+			}
+			handshakes := assemble(captured)
+			if len(handshakes) != 1 {
+				t.Fatalf("got %d handshakes, want 1", len(handshakes))
+			}
+
+			h := handshakes[0]
+			if h.JA4T != test.ja4t || h.JA4TS != test.ja4ts {
+				t.Errorf("JA4T = %q and JA4TS = %q, want %q and %q", h.JA4T, h.JA4TS, test.ja4t, test.ja4ts)
+			}
+			if h.ClientTTL != test.clientTTL || h.ServerTTL != test.serverTTL {
+				t.Errorf("ClientTTL = %d and ServerTTL = %d, want %d and %d", h.ClientTTL, h.ServerTTL, test.clientTTL, test.serverTTL)
+			}
+		})
+	}
+}
+
+// The SYN-ACK is sent 3ms after the SYN and the ACK 7ms after that. The client's packets have a TTL of 64 and the server's 56.
+func TestTCPLatency(t *testing.T) {
+	packets := tcpConnection(testTLSHandshake(t, tls.VersionTLS12))
+	packets[1].Metadata().Timestamp = testStart.Add(3 * time.Millisecond)
+	packets[2].Metadata().Timestamp = testStart.Add(10 * time.Millisecond)
+	for i, p := range packets[3:] {
+		p.Metadata().Timestamp = testStart.Add(time.Duration(20+i) * time.Millisecond)
+	}
+
+	fromServer := func(p gopacket.Packet) bool {
+		return p.NetworkLayer().NetworkFlow().Src().String() == "10.0.0.2"
+	}
+	tests := []struct {
+		name                     string
+		captured                 func(i int, p gopacket.Packet) bool
+		synToSYNACK, synACKToACK time.Duration
+		ja4l, ja4ls              string
+	}{
+		{"all packets", func(int, gopacket.Packet) bool { return true }, 3 * time.Millisecond, 7 * time.Millisecond, "3500_64", "1500_56"},
+		// Nothing the client sent after its SYN was captured
+		{"ACK missed", func(i int, p gopacket.Packet) bool { return i == 0 || fromServer(p) }, 3 * time.Millisecond, 0, "", "1500_56"},
+		{"SYN-ACK missed", func(i int, p gopacket.Packet) bool { return i != 1 }, 0, 0, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var captured []gopacket.Packet
+			for i, p := range packets {
+				if test.captured(i, p) {
+					captured = append(captured, p)
+				}
+			}
+			handshakes := assemble(captured)
+			if len(handshakes) != 1 {
+				t.Fatalf("got %d handshakes, want 1", len(handshakes))
+			}
+
+			h := handshakes[0]
+			if h.SYNToSYNACK != test.synToSYNACK || h.SYNACKToACK != test.synACKToACK {
+				t.Errorf("SYNToSYNACK = %v and SYNACKToACK = %v, want %v and %v", h.SYNToSYNACK, h.SYNACKToACK, test.synToSYNACK, test.synACKToACK)
+			}
+			if h.JA4L != test.ja4l || h.JA4LS != test.ja4ls {
+				t.Errorf("JA4L = %q and JA4LS = %q, want %q and %q", h.JA4L, h.JA4LS, test.ja4l, test.ja4ls)
+			}
+		})
+	}
+}
+
+// The Linux and Windows SYNs have the options those stacks send by default.
+func TestCalculateJA4T(t *testing.T) {
+	tests := []struct {
+		name string
+		tcp  *layers.TCP
+		ja4t string
+	}{
+		{
+			name: "Linux",
+			tcp: &layers.TCP{Window: 64240, Options: []layers.TCPOption{
+				{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}},
+				{OptionType: layers.TCPOptionKindSACKPermitted, OptionLength: 2},
+				{OptionType: layers.TCPOptionKindTimestamps, OptionLength: 10, OptionData: make([]byte, 8)},
+				{OptionType: layers.TCPOptionKindNop, OptionLength: 1},
+				{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{7}},
+			}},
+			ja4t: "64240_2-4-8-1-3_1460_7",
+		},
+		{
+			name: "Windows",
+			tcp: &layers.TCP{Window: 64240, Options: []layers.TCPOption{
+				{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}},
+				{OptionType: layers.TCPOptionKindNop, OptionLength: 1},
+				{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{8}},
+				{OptionType: layers.TCPOptionKindNop, OptionLength: 1},
+				{OptionType: layers.TCPOptionKindNop, OptionLength: 1},
+				{OptionType: layers.TCPOptionKindSACKPermitted, OptionLength: 2},
+			}},
+			ja4t: "64240_2-1-3-1-1-4_1460_8",
+		},
+		{"no options", &layers.TCP{Window: 1024}, "1024_00_00_00"},
+		{
+			name: "malformed MSS",
+			tcp:  &layers.TCP{Window: 1024, Options: []layers.TCPOption{{OptionType: layers.TCPOptionKindMSS, OptionLength: 3, OptionData: []byte{5}}}},
+			ja4t: "1024_2_00_00",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := calculateJA4T(test.tcp); got != test.ja4t {
+				t.Errorf("calculateJA4T() = %q, want %q", got, test.ja4t)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+//go:build ignore
+// +build ignore
+
+// generate records real TLS handshakes between curl or openssl s_client and openssl s_server, by relaying
+// them through a local proxy, and writes them out as capture files for the tests to read.
+//
+//	go run generate.go
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const (
+	serverPort = 14433
+	relayPort  = 14434
+)
+
+// captures maps each capture file to the client command which is recorded in it.
+var captures = map[string][]string{
+	"curl-tls12.pcap":     {"curl", "-sk", "--tlsv1.2", "--tls-max", "1.2", "--resolve", fmt.Sprintf("example.com:%d:127.0.0.1", relayPort), fmt.Sprintf("https://example.com:%d/", relayPort)},
+	"curl-tls13.pcap":     {"curl", "-sk", "--tlsv1.3", "--resolve", fmt.Sprintf("example.com:%d:127.0.0.1", relayPort), fmt.Sprintf("https://example.com:%d/", relayPort)},
+	"s_client-tls12.pcap": {"sh", "-c", fmt.Sprintf("echo | openssl s_client -tls1_2 -servername example.com -connect 127.0.0.1:%d", relayPort)},
+}
+
+// segment is the data read from one side of the relayed connection in one go.
+type segment struct {
+	fromClient bool
+	data       []byte
+}
+
+func main() {
+	dir, err := ioutil.TempDir("", "generate")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cert, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	run("openssl", "req", "-x509", "-newkey", "ec", "-pkeyopt", "ec_paramgen_curve:P-256", "-nodes", "-days", "3650",
+		"-subj", "/CN=example.com/O=osquery-ja3 test", "-addext", "subjectAltName=DNS:example.com", "-keyout", key, "-out", cert)
+
+	server := exec.Command("openssl", "s_server", "-quiet", "-www", "-accept", fmt.Sprint(serverPort), "-cert", cert, "-key", key)
+	if err := server.Start(); err != nil {
+		log.Fatal(err)
+	}
+	defer server.Process.Kill()
+	waitForServer()
+
+	for file, client := range captures {
+		segments := relay(client)
+		if err := writeCapture(file, segments); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s: %d segments", file, len(segments))
+	}
+}
+
+func run(name string, args ...string) {
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		log.Fatalf("%s: %v\n%s", name, err, out)
+	}
+}
+
+func waitForServer() {
+	for i := 0; i < 50; i++ {
+		conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", serverPort), &tls.Config{InsecureSkipVerify: true})
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Fatal("openssl s_server didn't start")
+}
+
+// relay runs the client through a proxy to the server, recording what each side sends.
+func relay(client []string) []segment {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", relayPort))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer listener.Close()
+
+	var mu sync.Mutex
+	var segments []segment
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		clientConn, err := listener.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		serverConn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", serverPort))
+		if err != nil {
+			log.Fatal(err)
+		}
+		copy := func(dst, src net.Conn, fromClient bool) {
+			buf := make([]byte, 65536)
+			for {
+				n, err := src.Read(buf)
+				if n > 0 {
+					mu.Lock()
+					segments = append(segments, segment{fromClient, append([]byte{}, buf[:n]...)})
+					dst.Write(buf[:n])
+					mu.Unlock()
+				}
+				if err != nil {
+					dst.(*net.TCPConn).CloseWrite()
+					return
+				}
+			}
+		}
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { copy(serverConn, clientConn, true); wg.Done() }()
+		go func() { copy(clientConn, serverConn, false); wg.Done() }()
+		wg.Wait()
+		clientConn.Close()
+		serverConn.Close()
+	}()
+
+	if out, err := exec.Command(client[0], client[1:]...).CombinedOutput(); err != nil && !strings.Contains(client[0], "sh") {
+		log.Fatalf("%s: %v\n%s", client[0], err, out)
+	}
+	<-done
+	return segments
+}
+
+// packet is a TCP segment to write to a capture file.
+type packet struct {
+	fromClient bool
+	tcp        *layers.TCP
+	payload    []byte
+}
+
+// writeCapture writes the segments out as a TCP connection from 10.0.0.1:50000 to 10.0.0.2:443, starting with
+// the three-way handshake and ending with both sides closing it. Packets are 1ms apart to keep the timings stable.
+func writeCapture(file string, segments []segment) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		return err
+	}
+
+	clientIP, serverIP := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	clientSeq, serverSeq := uint32(1000), uint32(5000)
+	timestamp := time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+	options := []layers.TCPOption{
+		{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}},
+		{OptionType: layers.TCPOptionKindSACKPermitted, OptionLength: 2},
+		{OptionType: layers.TCPOptionKindTimestamps, OptionLength: 10, OptionData: make([]byte, 8)},
+		{OptionType: layers.TCPOptionKindNop},
+		{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{7}},
+	}
+	write := func(fromClient bool, tcp *layers.TCP, payload []byte) error {
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: clientIP, DstIP: serverIP}
+		tcp.SrcPort, tcp.DstPort, tcp.Seq, tcp.Ack, tcp.Window = 50000, 443, clientSeq, serverSeq, 64240
+		if !fromClient {
+			ip.TTL, ip.SrcIP, ip.DstIP = 56, serverIP, clientIP
+			tcp.SrcPort, tcp.DstPort, tcp.Seq, tcp.Ack, tcp.Window = 443, 50000, serverSeq, clientSeq, 65160
+		}
+		if tcp.SYN && tcp.ACK {
+			tcp.Ack = clientSeq
+		}
+		tcp.SetNetworkLayerForChecksum(ip)
+		eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2}, EthernetType: layers.EthernetTypeIPv4}
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(payload)); err != nil {
+			return err
+		}
+		timestamp = timestamp.Add(time.Millisecond)
+		info := gopacket.CaptureInfo{Timestamp: timestamp, CaptureLength: len(buf.Bytes()), Length: len(buf.Bytes())}
+		return w.WritePacket(info, buf.Bytes())
+	}
+
+	packets := []packet{
+		{true, &layers.TCP{SYN: true, Options: options}, nil},
+		{false, &layers.TCP{SYN: true, ACK: true, Options: options}, nil},
+		{true, &layers.TCP{ACK: true}, nil},
+	}
+	for _, s := range segments {
+		for data := s.data; len(data) > 0; {
+			n := len(data)
+			if n > 1460 {
+				n = 1460
+			}
+			packets = append(packets, packet{s.fromClient, &layers.TCP{ACK: true, PSH: true}, data[:n]})
+			data = data[n:]
+		}
+	}
+	packets = append(packets, packet{true, &layers.TCP{FIN: true, ACK: true}, nil}, packet{false, &layers.TCP{FIN: true, ACK: true}, nil})
+
+	for _, p := range packets {
+		if err := write(p.fromClient, p.tcp, p.payload); err != nil {
+			return err
+		}
+		length := uint32(len(p.payload))
+		if p.tcp.SYN || p.tcp.FIN {
+			length++
+		}
+		if p.fromClient {
+			clientSeq += length
+		} else {
+			serverSeq += length
+		}
+	}
+	return nil
+}
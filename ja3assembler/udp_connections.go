@@ -0,0 +1,104 @@
+package ja3assembler
+
+import (
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// udpConnections tracks the connections of a protocol which does its handshake over UDP, like QUIC and DTLS.
+// Without anything like a FIN to say a connection is over, each connection is reported once its protocol has
+// seen all of the handshake or once it hasn't sent a packet for the timeout.
+type udpConnections struct {
+	callback       func(Handshake)
+	timeout        time.Duration
+	maxConnections int
+	// connections are indexed by the flows in both directions
+	connections map[key]trackedConnection
+	lastExpiry  time.Time
+}
+
+// trackedConnection is a connection tracked by udpConnections. Implementations embed udpHandshake.
+type trackedConnection interface {
+	state() *udpHandshake
+	// discard frees everything buffered for a connection once its handshake has been reported
+	discard()
+}
+
+// udpHandshake is the state of a connection's handshake common to every protocol.
+type udpHandshake struct {
+	client   key // flows of the packets sent by the client
+	lastSeen time.Time
+
+	clientHello, serverHello Handshake
+	done                     bool // if true, the handshake has been reported and further packets are ignored
+}
+
+func (h *udpHandshake) state() *udpHandshake {
+	return h
+}
+
+func newUDPConnections(callback func(Handshake), timeout time.Duration, maxConnections int) udpConnections {
+	return udpConnections{
+		callback:       callback,
+		timeout:        timeout,
+		maxConnections: maxConnections,
+		connections:    map[key]trackedConnection{},
+	}
+}
+
+// full returns whether no more connections can be tracked until some have been finished.
+func (c *udpConnections) full() bool {
+	return len(c.connections) >= 2*c.maxConnections
+}
+
+// track starts tracking a connection started by a client's packet with the given flows.
+func (c *udpConnections) track(netFlow, udpFlow gopacket.Flow, conn trackedConnection) {
+	c.connections[key{netFlow, udpFlow}] = conn
+	c.connections[key{netFlow.Reverse(), udpFlow.Reverse()}] = conn
+}
+
+// expireTimedOut expires the connections which have timed out by the time of a packet, at most once a second of capture time.
+func (c *udpConnections) expireTimedOut(timestamp time.Time) {
+	if timestamp.Sub(c.lastExpiry) > time.Second {
+		c.expire(timestamp.Add(-c.timeout))
+		c.lastExpiry = timestamp
+	}
+}
+
+// finish reports a connection's handshake, if any of it was seen, and stops processing the connection.
+func (c *udpConnections) finish(conn trackedConnection) {
+	h := conn.state()
+	h.done = true
+	conn.discard()
+	if h.clientHello.JA3 == "" && h.serverHello.JA3S == "" {
+		return
+	}
+
+	handshake := h.clientHello
+	handshake.merge(h.serverHello)
+	c.callback(handshake)
+}
+
+// expire finishes and forgets about connections which haven't sent a packet of their handshake since the cutoff.
+func (c *udpConnections) expire(cutoff time.Time) {
+	for k, conn := range c.connections {
+		if conn.state().lastSeen.After(cutoff) {
+			continue
+		}
+		delete(c.connections, k)
+		if !conn.state().done {
+			c.finish(conn)
+		}
+	}
+}
+
+// flushAll finishes all connections still in progress.
+func (c *udpConnections) flushAll() {
+	for k, conn := range c.connections {
+		delete(c.connections, k)
+		if !conn.state().done {
+			c.finish(conn)
+		}
+	}
+}
@@ -2,32 +2,66 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/bradleyjkemp/osquery-ja3/ja3assembler"
-	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 	"github.com/kolide/osquery-go"
 	"github.com/kolide/osquery-go/plugin/table"
 )
 
-const (
-	eventRetentionPeriod = 24 * time.Hour
-)
-
 var (
-	extensionFlags = flag.NewFlagSet("osquery-ja3", flag.ExitOnError)
-	fSocket        = extensionFlags.String("socket", "flag-not-provided", "osqueryd socket to connect to")
-	verbose        = extensionFlags.Bool("verbose", false, "enable verbose logging")
-	_              = extensionFlags.Int("timeout", 0, "timeout")
-	_              = extensionFlags.Int("interval", 0, "interval")
+	pcapFiles         stringListFlag
+	extensionFlags    = flag.NewFlagSet("osquery-ja3", flag.ExitOnError)
+	fSocket           = extensionFlags.String("socket", "flag-not-provided", "osqueryd socket to connect to")
+	verbose           = extensionFlags.Bool("verbose", false, "enable verbose logging")
+	fRetention        = extensionFlags.Duration("retention", 24*time.Hour, "how long to keep handshake events for, 0 to keep them until the store is full")
+	fMaxEvents        = extensionFlags.Int("max_events", 1000000, "maximum number of handshake events to keep, 0 for no limit")
+	fMaxBytes         = extensionFlags.Int("max_bytes", 256<<20, "approximate maximum memory to use for handshake events, 0 for no limit")
+	fExpireOnRead     = extensionFlags.Bool("expire_on_read", false, "only return each handshake event once, like osquery's own evented tables")
+	fEventsFile       = extensionFlags.String("events_file", "", "file to persist handshake events to so that they survive restarts")
+	fSummaryRetention = extensionFlags.Duration("summary_retention", 30*24*time.Hour, "how long to keep fingerprints in the summary table after they were last seen, 0 to keep them forever")
+	fSummaryMax       = extensionFlags.Int("summary_max_entries", 100000, "maximum number of fingerprints to keep in the summary table, 0 for no limit")
+	fSummaryFile      = extensionFlags.String("summary_file", "", "file to persist the fingerprint summary to so that it survives restarts")
+	_                 = extensionFlags.Int("timeout", 0, "timeout")
+	_                 = extensionFlags.Int("interval", 0, "interval")
 )
 
+func init() {
+	extensionFlags.Var(&pcapFiles, "pcap", "read packets from this pcap/pcapng file instead of live interfaces (can be repeated)")
+}
+
+// stringListFlag collects the values of a flag that can be provided multiple times
+type stringListFlag []string
+
+func (s *stringListFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringListFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// flagProvided returns whether a flag was explicitly set rather than left as its default
+func flagProvided(flags *flag.FlagSet, name string) bool {
+	provided := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			provided = true
+		}
+	})
+	return provided
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "print" {
+		printHandshakes(os.Args[2:])
+		return
+	}
+
 	err := extensionFlags.Parse(os.Args[1:])
 
 	server, err := osquery.NewExtensionManagerServer("tls_handshake_signatures", *fSocket)
@@ -35,70 +69,55 @@ func main() {
 		log.Fatalf("Error creating extension: %s\n", err)
 	}
 
-	ifaces, err := pcap.FindAllDevs()
-	if err != nil {
-		log.Fatalf("Failed to get network interfaces: %v", err)
+	retention, summaryRetention := *fRetention, *fSummaryRetention
+	if len(pcapFiles) > 0 {
+		// Packets from capture files are timestamped in the past so expiring them relative to now would discard them
+		if !flagProvided(extensionFlags, "retention") {
+			retention = 0
+		}
+		if !flagProvided(extensionFlags, "summary_retention") {
+			summaryRetention = 0
+		}
 	}
-	for _, iface := range ifaces {
-		go logJA3Hashes(iface.Name)
+	events = newEventStore(retention, *fMaxEvents, *fMaxBytes)
+	if *fEventsFile != "" {
+		eventLog, persisted, err := openEventLog(*fEventsFile)
+		if err != nil {
+			log.Fatalf("Failed to open events file: %v", err)
+		}
+		events.attachLog(eventLog, persisted)
+	}
+	summary = newFingerprintSummary(summaryRetention, *fSummaryMax)
+	if *fSummaryFile != "" {
+		if err := summary.load(*fSummaryFile); err != nil {
+			log.Fatalf("Failed to load summary file: %v", err)
+		}
+		go saveSummaryPeriodically(*fSummaryFile, time.Minute)
+	}
+
+	if len(pcapFiles) > 0 {
+		go readPcapFiles(pcapFiles, logHandshake)
+	} else {
+		ifaces, err := pcap.FindAllDevs()
+		if err != nil {
+			log.Fatalf("Failed to get network interfaces: %v", err)
+		}
+		for _, iface := range ifaces {
+			go logJA3Hashes(iface.Name, logHandshake)
+		}
 	}
 
 	// Create and register a new table plugin with the server.
 	// table.NewPlugin requires the table plugin name,
 	// a slice of Columns and a Generate function.
-	server.RegisterPlugin(table.NewPlugin("tls_handshake_signatures", []table.ColumnDefinition{
-		table.IntegerColumn("time"),
-		table.TextColumn("ja3"),
-		table.TextColumn("ja3s"),
-		table.TextColumn("sni"),
-	}, generateEventsTable))
+	server.RegisterPlugin(table.NewPlugin("tls_handshake_signatures", handshakeColumns, generateEventsTable))
+	server.RegisterPlugin(table.NewPlugin("tls_handshake_store_stats", storeStatsColumns, generateStoreStatsTable))
+	server.RegisterPlugin(table.NewPlugin("tls_fingerprint_summary", summaryColumns, generateSummaryTable))
+	server.RegisterPlugin(table.NewPlugin("tls_certificates", certificateColumns, generateCertificatesTable))
+	server.RegisterPlugin(table.NewPlugin("ssh_handshake_signatures", sshColumns, generateSSHTable))
+	server.RegisterPlugin(table.NewPlugin("http_request_signatures", httpColumns, generateHTTPTable))
+	server.RegisterPlugin(table.NewPlugin("http2_connection_signatures", http2Columns, generateHTTP2Table))
 	if err := server.Run(); err != nil {
 		log.Fatalln(err)
 	}
 }
-
-func logJA3Hashes(iface string) {
-	pcapHandle, err := pcap.OpenLive(iface, 1024, true, pcap.BlockForever)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	defer pcapHandle.Close()
-	if *verbose {
-		fmt.Println("Logging JA3(S) hashes on", iface)
-		defer func() {
-			fmt.Println("Stopped logging hashes on", iface)
-		}()
-	}
-
-	err = pcapHandle.SetBPFFilter("tcp")
-	if err != nil {
-		panic(err)
-	}
-
-	packetSource := gopacket.NewPacketSource(pcapHandle, pcapHandle.LinkType())
-	packets := packetSource.Packets()
-	assembler := ja3assembler.NewAssembler(logHandshake)
-
-	for {
-		select {
-		case packet, ok := <-packets:
-			if !ok {
-				// Channel has closed
-				return
-			}
-
-			if packet.NetworkLayer() == nil || packet.TransportLayer() == nil || packet.TransportLayer().LayerType() != layers.LayerTypeTCP {
-				//Unusable
-				continue
-			}
-			transport := packet.TransportLayer()
-			tcp, ok := transport.(*layers.TCP)
-			if !ok {
-				// This should never happen but we check so that this doesn't panic
-				continue
-			}
-			assembler.Assemble(packet.NetworkLayer().NetworkFlow(), tcp)
-		}
-	}
-}
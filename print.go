@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/gopacket/pcap"
+	"github.com/kolide/osquery-go/plugin/table"
+)
+
+// printProtocol is a type of event which the print subcommand can print.
+type printProtocol struct {
+	table   eventTable                             // the osquery table showing the events
+	name    string                                 // the name of that table, included in the JSON output
+	columns []table.ColumnDefinition               // the columns of that table, printed by the csv format
+	row     func(handshakeEvent) map[string]string // converts an event into a row with the columns
+
+	// The fixed width columns printed by the table format. The full set of columns is too wide to be
+	// readable so this is limited to the hashes, which are always the same width, followed by one other column.
+	tableFormatColumns []tableFormatColumn
+}
+
+type tableFormatColumn struct {
+	name  string
+	width int
+}
+
+// printProtocols are the types of events that can be printed, by their name in --protocols.
+var printProtocols = map[string]printProtocol{
+	"tls": {handshakesTable, "tls_handshake_signatures", handshakeColumns, handshakeRow, []tableFormatColumn{
+		{"time", 10},
+		{"ja3", 32},
+		{"ja3s", 32},
+		{"ja4", 36},
+		{"ja4s", 25},
+		{"sni", 0},
+	}},
+	"ssh": {sshTable, "ssh_handshake_signatures", sshColumns, sshRow, []tableFormatColumn{
+		{"time", 10},
+		{"hassh", 32},
+		{"hassh_server", 32},
+		{"client_banner", 0},
+	}},
+	"http": {httpTable, "http_request_signatures", httpColumns, httpRow, []tableFormatColumn{
+		{"time", 10},
+		{"ja4h", 51},
+		{"host", 0},
+	}},
+	"http2": {http2Table, "http2_connection_signatures", http2Columns, http2Row, []tableFormatColumn{
+		{"time", 10},
+		{"akamai_fingerprint_hash", 32},
+		{"akamai_fingerprint", 0},
+	}},
+}
+
+// printHandshakes implements the print subcommand which streams handshakes to stdout without needing osqueryd.
+func printHandshakes(args []string) {
+	var interfaces, files stringListFlag
+	printFlags := flag.NewFlagSet("osquery-ja3 print", flag.ExitOnError)
+	printFlags.Var(&interfaces, "interface", "capture live packets from this interface (can be repeated, defaults to all interfaces)")
+	printFlags.Var(&files, "pcap", "read packets from this pcap/pcapng file instead of live interfaces (can be repeated)")
+	format := printFlags.String("format", "table", "output format: table, csv or json")
+	protocols := printFlags.String("protocols", "tls", "comma separated types of events to print: tls, ssh, http and http2 (the table and csv formats can only print one)")
+	printFlags.BoolVar(verbose, "verbose", false, "enable verbose logging")
+	printFlags.Parse(args)
+
+	printer, err := newHandshakePrinter(os.Stdout, *format, strings.Split(*protocols, ","))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if len(files) > 0 {
+		readPcapFiles(files, printer.print)
+		return
+	}
+
+	if len(interfaces) == 0 {
+		ifaces, err := pcap.FindAllDevs()
+		if err != nil {
+			log.Fatalf("Failed to get network interfaces: %v", err)
+		}
+		for _, iface := range ifaces {
+			interfaces = append(interfaces, iface.Name)
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	for _, iface := range interfaces {
+		wg.Add(1)
+		go func(iface string) {
+			defer wg.Done()
+			logJA3Hashes(iface, printer.print)
+		}(iface)
+	}
+	wg.Wait()
+}
+
+// handshakePrinter writes each event as soon as it's seen. It's safe for concurrent use by multiple captures.
+type handshakePrinter struct {
+	sync.Mutex
+	format    string
+	protocols []printProtocol
+	out       io.Writer
+	csv       *csv.Writer
+}
+
+func newHandshakePrinter(out io.Writer, format string, protocols []string) (*handshakePrinter, error) {
+	p := &handshakePrinter{format: format, out: out}
+	for _, name := range protocols {
+		protocol, ok := printProtocols[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown protocol %q", name)
+		}
+		p.protocols = append(p.protocols, protocol)
+	}
+	if len(p.protocols) == 0 {
+		return nil, fmt.Errorf("no protocols to print")
+	}
+	if len(p.protocols) > 1 && format != "json" {
+		// Each protocol has different columns so there's no single header
+		return nil, fmt.Errorf("the %s format can only print one protocol", format)
+	}
+
+	switch format {
+	case "table":
+		header := []string{}
+		for _, column := range p.protocols[0].tableFormatColumns {
+			header = append(header, fmt.Sprintf("%-*s", column.width, column.name))
+		}
+		fmt.Fprintln(out, strings.Join(header, " | "))
+	case "csv":
+		p.csv = csv.NewWriter(out)
+		header := []string{}
+		for _, column := range p.protocols[0].columns {
+			header = append(header, column.Name)
+		}
+		p.csv.Write(header)
+		p.csv.Flush()
+	case "json":
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+	return p, nil
+}
+
+func (p *handshakePrinter) print(event handshakeEvent) {
+	for _, protocol := range p.protocols {
+		if eventTables[protocol.table](&event) {
+			p.printRow(protocol, protocol.row(event))
+			return
+		}
+	}
+}
+
+func (p *handshakePrinter) printRow(protocol printProtocol, row map[string]string) {
+	p.Lock()
+	defer p.Unlock()
+
+	switch p.format {
+	case "table":
+		fields := []string{}
+		for _, column := range protocol.tableFormatColumns {
+			fields = append(fields, fmt.Sprintf("%-*s", column.width, row[column.name]))
+		}
+		fmt.Fprintln(p.out, strings.Join(fields, " | "))
+	case "csv":
+		record := []string{}
+		for _, column := range protocol.columns {
+			record = append(record, row[column.Name])
+		}
+		p.csv.Write(record)
+		p.csv.Flush()
+	case "json":
+		// Several protocols can be printed so say which table the row is from
+		row["table"] = protocol.name
+		line, err := json.Marshal(row)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		fmt.Fprintln(p.out, string(line))
+	}
+}
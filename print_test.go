@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bradleyjkemp/osquery-ja3/ja3assembler"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata with the current output")
+
+// printEvents are one event of every type, each over a connection whose TCP handshake was captured.
+func printEvents() []handshakeEvent {
+	connection := func(dstPort uint16) ja3assembler.Handshake {
+		return ja3assembler.Handshake{
+			SrcIP: net.IP{10, 0, 0, 1}, SrcPort: 50000, DstIP: net.IP{10, 0, 0, 2}, DstPort: dstPort, Transport: ja3assembler.TransportTCP,
+			JA4T: "64240_2-4-8-1-3_1460_7", ClientTTL: 64, JA4TS: "65160_2-4-8-1-3_1460_7", ServerTTL: 56,
+			SYNToSYNACK: 3 * time.Millisecond, SYNACKToACK: 7 * time.Millisecond, JA4L: "3500_64", JA4LS: "1500_56",
+		}
+	}
+
+	tls := connection(443)
+	tls.JA3 = "87b9bfc7da97115ed2276737b09f8d74"
+	tls.JA3String = "771,49196-49200,0-11-10,29-23,0"
+	tls.JA3S = "a548c224ffe85e7076d7897c27f6758c"
+	tls.JA3SString = "771,49196,65281-11-23"
+	tls.JA4 = "t12d2807h2_d943125447b4_a44c6288192a"
+	tls.JA4S = "t120300_c02c_460f64128655"
+	tls.SNI = "example.com"
+	tls.HelloLatency = 2 * time.Millisecond
+
+	ssh := connection(22)
+	ssh.SSH = &ja3assembler.SSHHandshake{
+		ClientBanner: "SSH-2.0-OpenSSH_9.2p1",
+		ServerBanner: "SSH-2.0-OpenSSH_8.9p1",
+		HASSH:        "ec7378c1a92f5a8dde7e8b7a1ddf33d1",
+		HASSHServer:  "b12d2871a1189eff20364cf5333619ee",
+	}
+
+	http := connection(80)
+	http.HTTP = &ja3assembler.HTTPRequest{
+		Method:    "GET",
+		Host:      "example.com",
+		UserAgent: "curl/7.88.1",
+		JA4H:      "ge11nn030000_a4ff1e3d2a27_000000000000_000000000000",
+	}
+
+	http2 := connection(80)
+	http2.HTTP2 = &ja3assembler.HTTP2Fingerprint{
+		Akamai:     "3:100,4:10485760,2:0|1048510465|0|m,s,a,p",
+		AkamaiHash: "605a1154008045d7e3cb3c6fb062c0ce",
+	}
+
+	events := []handshakeEvent{}
+	for i, handshake := range []ja3assembler.Handshake{tls, ssh, http, http2} {
+		events = append(events, handshakeEvent{
+			eid:       uint64(i),
+			time:      testStart.Add(time.Duration(i) * time.Second),
+			iface:     "eth0",
+			handshake: handshake,
+		})
+	}
+	return events
+}
+
+func TestHandshakePrinter(t *testing.T) {
+	tests := []struct {
+		format    string
+		protocols []string
+		golden    string
+	}{
+		{"table", []string{"tls"}, "tls.txt"},
+		{"table", []string{"ssh"}, "ssh.txt"},
+		{"table", []string{"http"}, "http.txt"},
+		{"table", []string{"http2"}, "http2.txt"},
+		{"csv", []string{"tls"}, "tls.csv"},
+		{"csv", []string{"ssh"}, "ssh.csv"},
+		{"json", []string{"tls", "ssh", "http", "http2"}, "all.json"},
+	}
+	for _, test := range tests {
+		t.Run(test.golden, func(t *testing.T) {
+			var out bytes.Buffer
+			p, err := newHandshakePrinter(&out, test.format, test.protocols)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, event := range printEvents() {
+				p.print(event)
+			}
+
+			golden := filepath.Join("testdata", "print", test.golden)
+			if *updateGolden {
+				if err := ioutil.WriteFile(golden, out.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("printed:\n%s\nwant:\n%s", out.Bytes(), want)
+			}
+		})
+	}
+}
+
+func TestHandshakePrinterInvalid(t *testing.T) {
+	tests := []struct {
+		format    string
+		protocols []string
+	}{
+		{"xml", []string{"tls"}},
+		{"json", []string{"quic"}},
+		{"json", []string{}},
+		{"table", []string{"tls", "ssh"}},
+		{"csv", []string{"tls", "ssh"}},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		if _, err := newHandshakePrinter(&out, test.format, test.protocols); err == nil {
+			t.Errorf("printing %v as %s didn't return an error", test.protocols, test.format)
+		}
+		if out.Len() != 0 {
+			t.Errorf("printing %v as %s wrote %q", test.protocols, test.format, out.String())
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package procinfo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// interfaceTTL is how long the container on the other end of an interface is cached for.
+// Failures are cached too as the namespaces of an interface's peer normally don't change.
+const interfaceTTL = time.Minute
+
+var (
+	// Docker, containerd and CRI-O all name a container's cgroup after its 64 character ID
+	containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+	// Kubernetes puts each pod in a cgroup named after its UID, with the dashes replaced by underscores under systemd
+	podUIDPattern = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+)
+
+// interfaces caches the containers found by lookupInterface, as finding one means listing every network namespace.
+var interfaces = struct {
+	sync.Mutex
+	byName map[string]*interfaceContainer
+}{byName: map[string]*interfaceContainer{}}
+
+type interfaceContainer struct {
+	ifindex, peerIndex int // to notice if the interface has been replaced by another with the same name
+	container          *Container
+	err                error
+	found              time.Time
+}
+
+// netNamespace is a network namespace along with the processes running in it.
+type netNamespace struct {
+	inode uint64
+	pids  []int
+}
+
+// listNetNamespaces groups all running processes by their network namespace.
+// Namespaces are returned in order of their lowest pid, so the host's namespace normally comes first.
+func listNetNamespaces() ([]*netNamespace, error) {
+	pids, err := listPids()
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := []*netNamespace{}
+	byInode := map[uint64]*netNamespace{}
+	for _, pid := range pids {
+		inode, err := readNetNamespace(pid)
+		if err != nil {
+			// The process may have exited or we may not have permission to inspect it
+			continue
+		}
+		ns := byInode[inode]
+		if ns == nil {
+			ns = &netNamespace{inode: inode}
+			byInode[inode] = ns
+			namespaces = append(namespaces, ns)
+		}
+		ns.pids = append(ns.pids, pid)
+	}
+	return namespaces, nil
+}
+
+// readNetNamespace returns the inode of a process's network namespace.
+func readNetNamespace(pid int) (uint64, error) {
+	link, err := os.Readlink(filepath.Join(procRoot, strconv.Itoa(pid), "ns", "net"))
+	if err != nil {
+		return 0, err
+	}
+
+	// The link looks like net:[4026531992]
+	var inode uint64
+	if _, err := fmt.Sscanf(link, "net:[%d]", &inode); err != nil {
+		return 0, fmt.Errorf("invalid namespace link %q", link)
+	}
+	return inode, nil
+}
+
+// readContainer reads the cgroup of a process and works out which container and pod it belongs to.
+func readContainer(pid int, netNamespace uint64) Container {
+	c := Container{NetNamespace: netNamespace}
+	cgroups, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return c
+	}
+
+	for _, line := range strings.Split(string(cgroups), "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[2] != "/" {
+			// The unified (cgroup v2) hierarchy is the most reliable so use it if the process is placed in it
+			c.Cgroup = fields[2]
+			break
+		}
+		if c.Cgroup == "" || c.Cgroup == "/" {
+			c.Cgroup = fields[2]
+		}
+	}
+
+	if ids := containerIDPattern.FindAllString(c.Cgroup, -1); len(ids) > 0 {
+		// Nested containers have several IDs in their path, the innermost one is the most specific
+		c.ContainerID = ids[len(ids)-1]
+	}
+	if match := podUIDPattern.FindStringSubmatch(c.Cgroup); match != nil {
+		c.PodUID = strings.Replace(match[1], "_", "-", -1)
+	}
+	return c
+}
+
+func lookupInterface(iface string) (*Container, error) {
+	ifindex, err := readInterfaceAttribute(iface, "ifindex")
+	if err != nil {
+		return nil, err
+	}
+	peerIndex, err := readInterfaceAttribute(iface, "iflink")
+	if err != nil {
+		return nil, err
+	}
+	if peerIndex == ifindex {
+		// This isn't one end of a veth pair so the traffic belongs to this namespace
+		return nil, ErrNotFound
+	}
+
+	interfaces.Lock()
+	defer interfaces.Unlock()
+	cached := interfaces.byName[iface]
+	if cached == nil || cached.ifindex != ifindex || cached.peerIndex != peerIndex || time.Since(cached.found) > interfaceTTL {
+		container, err := findPeerContainer(iface, peerIndex)
+		cached = &interfaceContainer{ifindex, peerIndex, container, err, time.Now()}
+		interfaces.byName[iface] = cached
+	}
+	return cached.container, cached.err
+}
+
+// findPeerContainer finds the container whose network namespace has the interface with the given index.
+func findPeerContainer(iface string, peerIndex int) (*Container, error) {
+	ownNamespace, err := readNetNamespace(os.Getpid())
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := listNetNamespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	// Interface indexes are only unique within a namespace so give up if more than one namespace could be the peer
+	var peer *netNamespace
+	for _, ns := range namespaces {
+		if ns.inode == ownNamespace || !interfaceIndexes(ns.pids[0])[peerIndex] {
+			continue
+		}
+		if peer != nil {
+			return nil, fmt.Errorf("peer of %s is ambiguous", iface)
+		}
+		peer = ns
+	}
+	if peer == nil {
+		return nil, ErrNotFound
+	}
+
+	// All the processes share the namespace but prefer one which is identifiable as being in a container
+	c := readContainer(peer.pids[0], peer.inode)
+	for _, pid := range peer.pids {
+		if candidate := readContainer(pid, peer.inode); candidate.ContainerID != "" {
+			c = candidate
+			break
+		}
+	}
+	return &c, nil
+}
+
+func readInterfaceAttribute(iface, attribute string) (int, error) {
+	value, err := ioutil.ReadFile(filepath.Join("/sys/class/net", iface, attribute))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(value)))
+}
+
+// interfaceIndexes returns the indexes of the interfaces in a process's network namespace.
+// There's no single procfs file listing them so this combines the IPv4 multicast and IPv6 address tables.
+func interfaceIndexes(pid int) map[int]bool {
+	indexes := map[int]bool{}
+	dir := filepath.Join(procRoot, strconv.Itoa(pid), "net")
+
+	if igmp, err := ioutil.ReadFile(filepath.Join(dir, "igmp")); err == nil {
+		// Idx	Device    : Count Querier	Group    Users Timer	Reporter
+		for _, line := range strings.Split(string(igmp), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || strings.HasPrefix(line, "\t") {
+				continue
+			}
+			if index, err := strconv.Atoi(fields[0]); err == nil {
+				indexes[index] = true
+			}
+		}
+	}
+
+	if inet6, err := ioutil.ReadFile(filepath.Join(dir, "if_inet6")); err == nil {
+		// address ifindex prefix-length scope flags device
+		for _, line := range strings.Split(string(inet6), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 6 {
+				continue
+			}
+			if index, err := strconv.ParseInt(fields[1], 16, 32); err == nil {
+				indexes[int(index)] = true
+			}
+		}
+	}
+	return indexes
+}
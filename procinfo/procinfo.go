@@ -0,0 +1,52 @@
+// Package procinfo attributes network connections to the local processes which own them.
+package procinfo
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrNotFound is returned when no local process owns the connection, e.g. because it has already exited.
+var ErrNotFound = errors.New("no process found for connection")
+
+// Process describes the local process which owns a socket.
+type Process struct {
+	PID     int
+	Name    string
+	Path    string
+	Cmdline string
+	UID     int
+	Container
+}
+
+// Container describes the cgroup and network namespace that a process runs in.
+// The container and pod IDs are only set if they can be found in the cgroup path.
+type Container struct {
+	NetNamespace uint64 // inode of the network namespace
+	Cgroup       string
+	ContainerID  string
+	PodUID       string
+}
+
+// LookupTCP finds the process owning the TCP socket with the given local and remote endpoints.
+// Every network namespace is searched so that connections made from inside containers can be found.
+func LookupTCP(localIP net.IP, localPort uint16, remoteIP net.IP, remotePort uint16) (*Process, error) {
+	return lookupTCP(localIP, localPort, remoteIP, remotePort)
+}
+
+// LookupUDP finds the process owning the UDP socket with the given local and remote endpoints.
+// Unlike TCP, UDP sockets are often unconnected so a socket bound to the local endpoint is also accepted.
+func LookupUDP(localIP net.IP, localPort uint16, remoteIP net.IP, remotePort uint16) (*Process, error) {
+	return lookupUDP(localIP, localPort, remoteIP, remotePort)
+}
+
+// LookupInterface finds the container on the other end of a veth interface.
+func LookupInterface(iface string) (*Container, error) {
+	return lookupInterface(iface)
+}
+
+// BootTime returns when the system was booted.
+func BootTime() (time.Time, error) {
+	return bootTime()
+}
@@ -0,0 +1,324 @@
+package procinfo
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// procRoot is where procfs is mounted
+const procRoot = "/proc"
+
+const (
+	// ownersTTL is how long the owners of sockets are cached for, so that exited processes and reused pids are noticed
+	ownersTTL = 10 * time.Second
+	// minListInterval limits how often a connection that isn't in any known network namespace causes them to be listed again
+	minListInterval = time.Second
+)
+
+// socketTables are the files under /proc/<pid> listing each protocol's sockets.
+// IPv4 connections made from dual-stack sockets are listed in the IPv6 table so both tables always need checking.
+var socketTables = map[string][]string{
+	"tcp": {"net/tcp", "net/tcp6"},
+	"udp": {"net/udp", "net/udp6"},
+}
+
+// owners caches which process owns each socket, as finding the owner of a socket means
+// reading the file descriptors of every process in its network namespace.
+var owners = &socketOwners{}
+
+type socketOwners struct {
+	sync.Mutex
+	namespaces []*netNamespace
+	pids       map[uint64]int // socket inode -> pid of a process with it open
+	owning     map[int]bool   // pids which own sockets in pids
+	refreshed  time.Time      // when the owners were last forgotten
+	listed     time.Time      // when the namespaces were last listed
+}
+
+func lookupTCP(localIP net.IP, localPort uint16, remoteIP net.IP, remotePort uint16) (*Process, error) {
+	return lookupSocket("tcp", localIP, localPort, remoteIP, remotePort)
+}
+
+func lookupUDP(localIP net.IP, localPort uint16, remoteIP net.IP, remotePort uint16) (*Process, error) {
+	return lookupSocket("udp", localIP, localPort, remoteIP, remotePort)
+}
+
+func lookupSocket(protocol string, localIP net.IP, localPort uint16, remoteIP net.IP, remotePort uint16) (*Process, error) {
+	owners.Lock()
+	defer owners.Unlock()
+
+	if time.Since(owners.refreshed) > ownersTTL {
+		if err := owners.list(); err != nil {
+			return nil, err
+		}
+		owners.pids, owners.owning, owners.refreshed = map[uint64]int{}, map[int]bool{}, time.Now()
+	}
+
+	p, err := owners.lookup(protocol, localIP, localPort, remoteIP, remotePort)
+	if err == ErrNotFound && time.Since(owners.listed) > minListInterval {
+		// The connection may be from a new container
+		if err := owners.list(); err != nil {
+			return nil, err
+		}
+		p, err = owners.lookup(protocol, localIP, localPort, remoteIP, remotePort)
+	}
+	return p, err
+}
+
+// lookup finds the process owning a socket in one of the known network namespaces.
+func (o *socketOwners) lookup(protocol string, localIP net.IP, localPort uint16, remoteIP net.IP, remotePort uint16) (*Process, error) {
+	// Each network namespace has its own socket tables so the connection could be in any of them
+	for _, ns := range o.namespaces {
+		inode, err := findSocketInode(protocol, ns.pids[0], localIP, localPort, remoteIP, remotePort)
+		if err != nil {
+			// Either the connection isn't in this namespace or all its processes have exited
+			continue
+		}
+
+		pid, ok := o.pids[inode]
+		if !ok {
+			pid, err = o.findOwner(ns, inode)
+			if err != nil {
+				continue
+			}
+		}
+		return readProcess(pid, ns.inode)
+	}
+	return nil, ErrNotFound
+}
+
+// list lists the network namespaces and the processes in them again.
+func (o *socketOwners) list() error {
+	namespaces, err := listNetNamespaces()
+	if err != nil {
+		return err
+	}
+	o.namespaces, o.listed = namespaces, time.Now()
+	return nil
+}
+
+// findOwner returns the pid of one of the processes in a namespace with an open file descriptor for the socket.
+// Every socket seen along the way is cached, and processes already known to own sockets are checked first
+// as they're the most likely to have opened more.
+func (o *socketOwners) findOwner(ns *netNamespace, inode uint64) (int, error) {
+	pids := make([]int, 0, len(ns.pids))
+	for _, pid := range ns.pids {
+		if o.owning[pid] {
+			pids = append(pids, pid)
+		}
+	}
+	for _, pid := range ns.pids {
+		if !o.owning[pid] {
+			pids = append(pids, pid)
+		}
+	}
+
+	for _, pid := range pids {
+		found := false
+		for _, socket := range readSocketInodes(pid) {
+			if _, ok := o.pids[socket]; !ok {
+				o.pids[socket] = pid
+				o.owning[pid] = true
+			}
+			found = found || socket == inode
+		}
+		if found {
+			return pid, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+// findSocketInode searches the socket tables of a process's network namespace for a connection
+// and returns the inode of its socket.
+func findSocketInode(protocol string, pid int, localIP net.IP, localPort uint16, remoteIP net.IP, remotePort uint16) (uint64, error) {
+	// An unconnected UDP socket can only be used if there isn't a connected one
+	var unconnected uint64
+	for _, table := range socketTables[protocol] {
+		inode, unconnectedInode, err := searchSocketTable(filepath.Join(procRoot, strconv.Itoa(pid), table), localIP, localPort, remoteIP, remotePort)
+		if err == nil {
+			return inode, nil
+		}
+		if err != ErrNotFound && !os.IsNotExist(err) {
+			return 0, err
+		}
+		if protocol == "udp" && unconnected == 0 {
+			unconnected = unconnectedInode
+		}
+	}
+	if unconnected != 0 {
+		return unconnected, nil
+	}
+	return 0, ErrNotFound
+}
+
+// searchSocketTable returns the inode of the socket connected between the given endpoints. If there isn't one,
+// the inode of an unconnected socket bound to the local endpoint is also returned along with ErrNotFound.
+func searchSocketTable(path string, localIP net.IP, localPort uint16, remoteIP net.IP, remotePort uint16) (uint64, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var unconnected uint64
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // skip the header line
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		ip, port, err := parseSocketAddress(fields[1])
+		if err != nil || port != localPort || !(ip.Equal(localIP) || ip.IsUnspecified()) {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil || inode == 0 {
+			// An inode of zero means the socket is in TIME_WAIT and no longer belongs to anyone
+			continue
+		}
+
+		remote, port, err := parseSocketAddress(fields[2])
+		switch {
+		case err != nil:
+			continue
+		case remote.IsUnspecified() && port == 0:
+			// Not connected to anything, which for a TCP socket means it's listening rather than the connection
+			if unconnected == 0 {
+				unconnected = inode
+			}
+		case ip.Equal(localIP) && port == remotePort && remote.Equal(remoteIP):
+			return inode, 0, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	return 0, unconnected, ErrNotFound
+}
+
+// parseSocketAddress parses an address such as "0100007F:01BB" from /proc/net/tcp{,6}.
+// The address is made of 32 bit words in host (i.e. little endian) byte order, and the port is big endian.
+func parseSocketAddress(s string) (net.IP, uint16, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return nil, 0, fmt.Errorf("invalid socket address %q", s)
+	}
+
+	ip, err := hex.DecodeString(parts[0])
+	if err != nil || (len(ip) != net.IPv4len && len(ip) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid socket address %q", s)
+	}
+	for word := 0; word < len(ip); word += 4 {
+		ip[word], ip[word+1], ip[word+2], ip[word+3] = ip[word+3], ip[word+2], ip[word+1], ip[word]
+	}
+
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid socket address %q", s)
+	}
+	return net.IP(ip), uint16(port), nil
+}
+
+// readSocketInodes returns the inodes of the sockets a process has open file descriptors for.
+func readSocketInodes(pid int) []uint64 {
+	fdDir := filepath.Join(procRoot, strconv.Itoa(pid), "fd")
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		// The process may have exited or we may not have permission to inspect it
+		return nil
+	}
+
+	inodes := []uint64{}
+	for _, fd := range fds {
+		link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err != nil {
+			continue
+		}
+		// The link looks like socket:[4026531992]
+		var inode uint64
+		if _, err := fmt.Sscanf(link, "socket:[%d]", &inode); err == nil {
+			inodes = append(inodes, inode)
+		}
+	}
+	return inodes
+}
+
+func readProcess(pid int, netNamespace uint64) (*Process, error) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	p := &Process{PID: pid, UID: -1, Container: readContainer(pid, netNamespace)}
+
+	comm, err := ioutil.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return nil, err
+	}
+	p.Name = strings.TrimSpace(string(comm))
+
+	// The remaining details are best effort as they may not be readable (e.g. for kernel threads)
+	p.Path, _ = os.Readlink(filepath.Join(dir, "exe"))
+	if cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		p.Cmdline = strings.TrimSpace(strings.Replace(string(cmdline), "\x00", " ", -1))
+	}
+	if status, err := ioutil.ReadFile(filepath.Join(dir, "status")); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			// Uid: real effective saved filesystem
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "Uid:" {
+				p.UID, _ = strconv.Atoi(fields[1])
+				break
+			}
+		}
+	}
+	return p, nil
+}
+
+// listPids returns the pids of all running processes.
+func listPids() ([]int, error) {
+	procs, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	pids := []int{}
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			// Not a process directory
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+func bootTime() (time.Time, error) {
+	stat, err := ioutil.ReadFile(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(stat), "\n") {
+		// btime is the boot time in seconds since the epoch
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "btime" {
+			seconds, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("boot time not found in %s", filepath.Join(procRoot, "stat"))
+}
@@ -0,0 +1,66 @@
+package procinfo
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+// dial opens a local TCP connection, returning its endpoints from the client's point of view.
+func dial(t *testing.T, listener net.Listener) (local, remote *net.TCPAddr) {
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().(*net.TCPAddr), conn.RemoteAddr().(*net.TCPAddr)
+}
+
+func TestLookupTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	for i := 0; i < 2; i++ {
+		local, remote := dial(t, listener)
+		p, err := LookupTCP(local.IP, uint16(local.Port), remote.IP, uint16(remote.Port))
+		if err != nil {
+			t.Fatalf("LookupTCP(%v, %v) returned error: %v", local, remote, err)
+		}
+		if p.PID != os.Getpid() {
+			t.Errorf("LookupTCP(%v, %v) returned pid %d, want %d", local, remote, p.PID, os.Getpid())
+		}
+		if !owners.owning[os.Getpid()] {
+			t.Error("owner of the socket wasn't cached")
+		}
+	}
+
+	local, _ := dial(t, listener)
+	if _, err := LookupTCP(local.IP, uint16(local.Port), local.IP, 1); err != ErrNotFound {
+		t.Errorf("LookupTCP of a missing connection returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestLookupTCPCached(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	local, remote := dial(t, listener)
+
+	if _, err := LookupTCP(local.IP, uint16(local.Port), remote.IP, uint16(remote.Port)); err != nil {
+		t.Fatal(err)
+	}
+	// Processes are only scanned when a socket isn't in the cache, so an unreadable cache entry shows it was used
+	owners.Lock()
+	for inode := range owners.pids {
+		owners.pids[inode] = -1
+	}
+	owners.Unlock()
+	if _, err := LookupTCP(local.IP, uint16(local.Port), remote.IP, uint16(remote.Port)); err == nil {
+		t.Error("LookupTCP didn't use the cached owner of the socket")
+	}
+}
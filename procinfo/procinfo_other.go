@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package procinfo
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+var errUnsupported = errors.New("process attribution is only supported on Linux")
+
+func lookupTCP(localIP net.IP, localPort uint16, remoteIP net.IP, remotePort uint16) (*Process, error) {
+	return nil, errUnsupported
+}
+
+func lookupUDP(localIP net.IP, localPort uint16, remoteIP net.IP, remotePort uint16) (*Process, error) {
+	return nil, errUnsupported
+}
+
+func lookupInterface(iface string) (*Container, error) {
+	return nil, errUnsupported
+}
+
+func bootTime() (time.Time, error) {
+	return time.Time{}, errUnsupported
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+
+	"github.com/kolide/osquery-go/plugin/table"
+)
+
+// sshColumns are the columns of the ssh_handshake_signatures table, in the order they're displayed.
+var sshColumns = eventColumns(
+	table.TextColumn("hassh"),
+	table.TextColumn("hassh_algorithms"),
+	table.TextColumn("hassh_server"),
+	table.TextColumn("hassh_server_algorithms"),
+	table.TextColumn("client_banner"),
+	table.TextColumn("server_banner"),
+)
+
+func generateSSHTable(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	return generateRows(queryContext, sshTable, sshRow), nil
+}
+
+func isSSHEvent(event *handshakeEvent) bool {
+	return event.handshake.SSH != nil
+}
+
+// sshRow converts an SSH handshake into a row with the sshColumns
+func sshRow(event handshakeEvent) map[string]string {
+	ssh := event.handshake.SSH
+	row := connectionRow(event)
+	row["hassh"] = ssh.HASSH
+	row["hassh_algorithms"] = ssh.HASSHAlgorithms
+	row["hassh_server"] = ssh.HASSHServer
+	row["hassh_server_algorithms"] = ssh.HASSHServerAlgorithms
+	row["client_banner"] = ssh.ClientBanner
+	row["server_banner"] = ssh.ServerBanner
+	return row
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kolide/osquery-go/plugin/table"
+)
+
+// maxDistinctDestinations limits how many destinations are remembered per fingerprint so that very
+// common fingerprints (e.g. a browser's) don't use unbounded memory. The counts stop increasing at this limit.
+const maxDistinctDestinations = 10000
+
+// summary is created in main once the flags configuring it have been parsed
+var summary *fingerprintSummary
+
+// summaryColumns are the columns of the tls_fingerprint_summary table.
+var summaryColumns = []table.ColumnDefinition{
+	table.TextColumn("ja3"),
+	table.TextColumn("ja3s"),
+	table.TextColumn("sni"),
+	table.IntegerColumn("first_seen"),
+	table.IntegerColumn("last_seen"),
+	table.BigIntColumn("count"),
+	table.BigIntColumn("distinct_dst_ips"),
+	table.BigIntColumn("distinct_destinations"),
+}
+
+type summaryKey struct {
+	JA3, JA3S, SNI string
+}
+
+// summaryEntry aggregates all the handshakes seen with the same summaryKey
+type summaryEntry struct {
+	FirstSeen    time.Time       `json:"first_seen"`
+	LastSeen     time.Time       `json:"last_seen"`
+	Count        uint64          `json:"count"`
+	DstIPs       map[string]bool `json:"dst_ips"`
+	Destinations map[string]bool `json:"destinations"` // ip:port pairs
+
+	element *list.Element // in fingerprintSummary.recent
+}
+
+// fingerprintSummary counts handshakes by fingerprint. It's updated as each handshake is seen, rather than
+// from the event store, so that it can keep fingerprints for longer than the raw events are retained.
+type fingerprintSummary struct {
+	sync.Mutex
+	entries    map[summaryKey]*summaryEntry
+	recent     *list.List    // the keys of the entries, least recently seen first
+	retention  time.Duration // entries not seen for this long are removed, zero means never
+	maxEntries int           // the least recently seen entries are evicted beyond this, zero means no limit
+
+	evicted uint64 // entries removed because of maxEntries
+}
+
+func newFingerprintSummary(retention time.Duration, maxEntries int) *fingerprintSummary {
+	return &fingerprintSummary{
+		entries:    map[summaryKey]*summaryEntry{},
+		recent:     list.New(),
+		retention:  retention,
+		maxEntries: maxEntries,
+	}
+}
+
+func (s *fingerprintSummary) add(event handshakeEvent) {
+	s.Lock()
+	defer s.Unlock()
+
+	h := event.handshake
+	key := summaryKey{h.JA3, h.JA3S, h.SNI}
+	entry := s.entries[key]
+	if entry == nil {
+		entry = &summaryEntry{
+			FirstSeen:    event.time,
+			LastSeen:     event.time,
+			DstIPs:       map[string]bool{},
+			Destinations: map[string]bool{},
+		}
+		s.insert(key, entry)
+	} else {
+		s.recent.MoveToBack(entry.element)
+	}
+
+	entry.Count++
+	if event.time.Before(entry.FirstSeen) {
+		entry.FirstSeen = event.time
+	}
+	if event.time.After(entry.LastSeen) {
+		entry.LastSeen = event.time
+	}
+	if h.DstIP != nil {
+		addBounded(entry.DstIPs, h.DstIP.String())
+		addBounded(entry.Destinations, net.JoinHostPort(h.DstIP.String(), strconv.Itoa(int(h.DstPort))))
+	}
+}
+
+func addBounded(set map[string]bool, value string) {
+	if len(set) < maxDistinctDestinations {
+		set[value] = true
+	}
+}
+
+// insert adds a new entry as the most recently seen, evicting the least recently seen entries if the
+// summary is full. The lock must be held.
+func (s *fingerprintSummary) insert(key summaryKey, entry *summaryEntry) {
+	for s.maxEntries > 0 && len(s.entries) >= s.maxEntries {
+		s.remove(s.recent.Front().Value.(summaryKey))
+		s.evicted++
+	}
+	entry.element = s.recent.PushBack(key)
+	s.entries[key] = entry
+}
+
+// remove deletes an entry. The lock must be held.
+func (s *fingerprintSummary) remove(key summaryKey) {
+	s.recent.Remove(s.entries[key].element)
+	delete(s.entries, key)
+}
+
+// expire removes entries which haven't been seen within the retention period. The lock must be held.
+func (s *fingerprintSummary) expire(now time.Time) {
+	if s.retention == 0 {
+		return
+	}
+
+	cutoff := now.Add(-s.retention)
+	for key, entry := range s.entries {
+		if entry.LastSeen.Before(cutoff) {
+			s.remove(key)
+		}
+	}
+}
+
+func generateSummaryTable(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	summary.Lock()
+	defer summary.Unlock()
+
+	summary.expire(time.Now())
+
+	rows := make([]map[string]string, 0, len(summary.entries))
+	for key, entry := range summary.entries {
+		rows = append(rows, map[string]string{
+			"ja3":                   key.JA3,
+			"ja3s":                  key.JA3S,
+			"sni":                   key.SNI,
+			"first_seen":            fmt.Sprint(entry.FirstSeen.Unix()),
+			"last_seen":             fmt.Sprint(entry.LastSeen.Unix()),
+			"count":                 fmt.Sprint(entry.Count),
+			"distinct_dst_ips":      fmt.Sprint(len(entry.DstIPs)),
+			"distinct_destinations": fmt.Sprint(len(entry.Destinations)),
+		})
+	}
+	return rows, nil
+}
+
+// persistedSummaryEntry is the on-disk form of a summary entry, as JSON objects can't have struct keys
+type persistedSummaryEntry struct {
+	Key   summaryKey    `json:"key"`
+	Entry *summaryEntry `json:"entry"`
+}
+
+// load replaces the summary with the one saved at path, if there is one.
+func (s *fingerprintSummary) load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	persisted := []persistedSummaryEntry{}
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return err
+	}
+
+	valid := persisted[:0]
+	for _, p := range persisted {
+		// Nothing useful can be recovered from a corrupt entry
+		if p.Entry != nil {
+			valid = append(valid, p)
+		}
+	}
+	// So that the least recently seen entries are the first to be evicted
+	sort.Slice(valid, func(i, j int) bool {
+		return valid[i].Entry.LastSeen.Before(valid[j].Entry.LastSeen)
+	})
+
+	s.Lock()
+	defer s.Unlock()
+	for _, p := range valid {
+		if p.Entry.DstIPs == nil {
+			p.Entry.DstIPs = map[string]bool{}
+		}
+		if p.Entry.Destinations == nil {
+			p.Entry.Destinations = map[string]bool{}
+		}
+		if _, ok := s.entries[p.Key]; ok {
+			s.remove(p.Key)
+		}
+		s.insert(p.Key, p.Entry)
+	}
+	return nil
+}
+
+// save atomically writes the summary to path.
+func (s *fingerprintSummary) save(path string) error {
+	s.Lock()
+	s.expire(time.Now())
+	persisted := make([]persistedSummaryEntry, 0, len(s.entries))
+	for key, entry := range s.entries {
+		persisted = append(persisted, persistedSummaryEntry{key, entry})
+	}
+	data, err := json.Marshal(persisted)
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// saveSummaryPeriodically saves the summary to path every interval, forever.
+func saveSummaryPeriodically(path string, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := summary.save(path); err != nil {
+			log.Printf("Failed to save fingerprint summary: %v", err)
+		}
+	}
+}
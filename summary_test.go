@@ -0,0 +1,155 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bradleyjkemp/osquery-ja3/ja3assembler"
+)
+
+// summaryEvent returns a TLS event seen the given number of seconds after testStart, going to 192.0.2.1 on the port.
+func summaryEvent(seconds int, ja3 string, port uint16) handshakeEvent {
+	return handshakeEvent{
+		time:      testStart.Add(time.Duration(seconds) * time.Second),
+		handshake: ja3assembler.Handshake{JA3: ja3, SNI: "example.com", DstIP: net.IP{192, 0, 2, 1}, DstPort: port},
+	}
+}
+
+// summaryKeys returns the JA3s of the summary's entries, least recently seen first.
+func summaryKeys(s *fingerprintSummary) []string {
+	keys := []string{}
+	for e := s.recent.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(summaryKey).JA3)
+	}
+	return keys
+}
+
+func TestFingerprintSummaryAdd(t *testing.T) {
+	s := newFingerprintSummary(0, 0)
+	s.add(summaryEvent(10, "a", 443))
+	s.add(summaryEvent(0, "a", 8443)) // seen out of order
+	s.add(summaryEvent(20, "a", 443))
+	s.add(summaryEvent(5, "b", 443))
+
+	entry := s.entries[summaryKey{JA3: "a", SNI: "example.com"}]
+	if entry == nil {
+		t.Fatal("no entry for a")
+	}
+	if !entry.FirstSeen.Equal(testStart) || !entry.LastSeen.Equal(testStart.Add(20*time.Second)) || entry.Count != 3 {
+		t.Errorf("entry seen %d times from %v to %v, want 3 times from 0s to 20s", entry.Count, entry.FirstSeen, entry.LastSeen)
+	}
+	if len(entry.DstIPs) != 1 || len(entry.Destinations) != 2 {
+		t.Errorf("entry has %d destination IPs and %d destinations, want 1 and 2", len(entry.DstIPs), len(entry.Destinations))
+	}
+	if got := summaryKeys(s); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("entries = %v, want [a b]", got)
+	}
+}
+
+func TestFingerprintSummaryEvict(t *testing.T) {
+	s := newFingerprintSummary(0, 2)
+	s.add(summaryEvent(0, "a", 443))
+	s.add(summaryEvent(1, "b", 443))
+	s.add(summaryEvent(2, "a", 443)) // a is now the most recently seen
+	s.add(summaryEvent(3, "c", 443))
+
+	if got := summaryKeys(s); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("entries = %v, want [a c]", got)
+	}
+	if s.evicted != 1 {
+		t.Errorf("evicted = %d, want 1", s.evicted)
+	}
+}
+
+func TestFingerprintSummaryExpire(t *testing.T) {
+	s := newFingerprintSummary(time.Minute, 0)
+	s.add(summaryEvent(0, "a", 443))
+	s.add(summaryEvent(30, "b", 443))
+	s.add(summaryEvent(90, "a", 443))
+
+	s.expire(testStart.Add(100 * time.Second))
+	if got := summaryKeys(s); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("entries = %v, want [a]", got)
+	}
+	if s.evicted != 0 {
+		t.Errorf("evicted = %d, want expired entries not to be counted", s.evicted)
+	}
+}
+
+func TestFingerprintSummarySaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "summary.json")
+
+	s := newFingerprintSummary(0, 0)
+	if err := s.load(path); err != nil {
+		t.Fatalf("loading a missing file returned %v", err)
+	}
+	s.add(summaryEvent(0, "a", 443))
+	s.add(summaryEvent(1, "b", 443))
+	s.add(summaryEvent(2, "c", 443))
+	s.add(summaryEvent(3, "a", 8443))
+	if err := s.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// A smaller summary keeps the most recently seen entries
+	loaded := newFingerprintSummary(0, 2)
+	if err := loaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := summaryKeys(loaded); !reflect.DeepEqual(got, []string{"c", "a"}) {
+		t.Errorf("loaded entries = %v, want [c a]", got)
+	}
+	key := summaryKey{JA3: "a", SNI: "example.com"}
+	got, want := loaded.entries[key], s.entries[key]
+	if !got.FirstSeen.Equal(want.FirstSeen) || !got.LastSeen.Equal(want.LastSeen) || got.Count != want.Count ||
+		!reflect.DeepEqual(got.DstIPs, want.DstIPs) || !reflect.DeepEqual(got.Destinations, want.Destinations) {
+		t.Errorf("loaded entry = %+v, want %+v", got, want)
+	}
+	loaded.add(summaryEvent(4, "a", 443))
+	if loaded.entries[key].Count != 3 {
+		t.Errorf("count after loading = %d, want 3", loaded.entries[key].Count)
+	}
+}
+
+func TestFingerprintSummaryLoadCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "summary.json")
+
+	data := `[{"key":{"JA3":"a"},"entry":null},{"key":{"JA3":"b"},"entry":{"count":1}},{"key":{"JA3":"c"}}]`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := newFingerprintSummary(0, 0)
+	if err := s.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := summaryKeys(s); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("entries = %v, want just [b]", got)
+	}
+	// The entry without any destinations can still be added to
+	s.add(handshakeEvent{handshake: ja3assembler.Handshake{JA3: "b", DstIP: net.IP{192, 0, 2, 1}}})
+	if entry := s.entries[summaryKey{JA3: "b"}]; entry.Count != 2 || len(entry.DstIPs) != 1 {
+		t.Errorf("entry = %+v, want 2 handshakes to 1 destination", entry)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := newFingerprintSummary(0, 0).load(path); err == nil {
+		t.Error("loading an invalid file didn't return an error")
+	}
+}